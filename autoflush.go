@@ -0,0 +1,82 @@
+package blynk
+
+import (
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// autoFlushMaxBatch is the number of buffered writes sent right away without
+// waiting for the flush interval.
+const autoFlushMaxBatch = 32
+
+type pinWrite struct {
+	pin   int
+	value string
+}
+
+// SetAutoFlush buffers VirtualWrite calls and sends them in one write at most
+// every interval, or as soon as autoFlushMaxBatch writes are buffered. A zero
+// interval disables buffering and sends what is pending.
+func (g *Blynk) SetAutoFlush(interval time.Duration) {
+	g.lock.Lock()
+	g.autoFlush = interval
+	g.lock.Unlock()
+	if interval <= 0 {
+		g.flushWrites()
+	}
+}
+
+// bufferWrite reports whether the write was buffered for the next flush.
+func (g *Blynk) bufferWrite(pin int, value string) bool {
+	g.lock.Lock()
+	if g.autoFlush <= 0 {
+		g.lock.Unlock()
+		return false
+	}
+	g.flushBuf = append(g.flushBuf, pinWrite{pin: pin, value: value})
+	full := len(g.flushBuf) >= autoFlushMaxBatch
+	if len(g.flushBuf) == 1 && !full {
+		g.flushTimer = time.AfterFunc(g.autoFlush, g.flushWrites)
+	}
+	g.lock.Unlock()
+
+	if full {
+		g.flushWrites()
+	}
+	return true
+}
+
+func (g *Blynk) flushWrites() {
+	g.lock.Lock()
+	writes := g.flushBuf
+	g.flushBuf = nil
+	if g.flushTimer != nil {
+		g.flushTimer.Stop()
+		g.flushTimer = nil
+	}
+	g.lock.Unlock()
+	if len(writes) == 0 {
+		return
+	}
+
+	msgs := make([]BlynkMessage, len(writes))
+	for i, w := range writes {
+		msgs[i].Head.Command = BLYNK_CMD_HARDWARE
+		msgs[i].Head.MessageId = g.getMessageID()
+		msgs[i].Body.AddString("vw")
+		msgs[i].Body.AddInt(w.pin)
+		msgs[i].Body.AddString(w.value)
+		msgs[i].Head.Length = msgs[i].Body.Len()
+	}
+	if err := g.sendMessages(msgs...); err != nil {
+		slog.Printf("[ERROR] auto flush: %d writes failed, %s", len(writes), err.Error())
+		return
+	}
+
+	g.lock.Lock()
+	for _, w := range writes {
+		g.lastWritten[w.pin] = w.value
+	}
+	g.lock.Unlock()
+}
@@ -0,0 +1,36 @@
+package blynk
+
+// PendingWrites returns the number of writes not yet delivered, those kept
+// in the offline buffer and those waiting for a reliable send ack.
+func (g *Blynk) PendingWrites() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return len(g.offline) + len(g.unacked)
+}
+
+// OnBackpressure sets fn to be called when the pending writes reach
+// highWater, it fires again only after the queue dropped below the mark.
+func (g *Blynk) OnBackpressure(highWater int, fn func(depth int)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.highWater = highWater
+	g.onBackpressure = fn
+	g.aboveHighWater = false
+}
+
+func (g *Blynk) checkBackpressure() {
+	g.lock.Lock()
+	depth := len(g.offline) + len(g.unacked)
+	fn := g.onBackpressure
+	if fn == nil || g.highWater <= 0 {
+		g.lock.Unlock()
+		return
+	}
+	crossed := depth >= g.highWater && !g.aboveHighWater
+	g.aboveHighWater = depth >= g.highWater
+	g.lock.Unlock()
+
+	if crossed {
+		fn(depth)
+	}
+}
@@ -1,12 +1,18 @@
 package blynk
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/mail"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,8 +21,14 @@ import (
 	slog "github.com/OloloevReal/go-simple-log"
 )
 
+// Version is the library version, advertised to the server as "ver" in the
+// hardware info like the other Blynk libraries do.
 const Version = "0.0.7"
 
+// ProtocolVersion is the protocol version the client speaks. It is not sent,
+// a "ver" hint from the server is compared against it.
+const ProtocolVersion = "1.0"
+
 type Blynk struct {
 	APIkey          string
 	server          string
@@ -31,10 +43,114 @@ type Blynk struct {
 	timeoutMAX      time.Duration
 	lock            sync.Mutex
 	ssl             bool
+	fallbackPlain   bool
+	caProvider      func() ([]byte, error)
+	clientCerts     []tls.Certificate
+	tcpKeepAlive    time.Duration
+	noDelay         bool
+	writeRetries    int
+	writeBackoff    time.Duration
+	retryAll        bool
+	readOnly        bool
+	autoReconnect   bool
+	retryAuthFail   bool
 	cancel          chan bool
+	stopOnce        sync.Once
 	readers         map[uint]func(uint, io.Writer)
 	writers         map[uint]func(uint, io.Reader)
 	recvMsg         chan []byte
+	dropped         uint64
+	debounces       map[int]*debounce
+	lastWritten     map[int]string
+	streams         map[string]int
+	listeners       []readListener
+	listenerID      int
+	diagnostics     func() map[string]string
+	onSend          func(BlynkMessage)
+	health          linkHealth
+	onInternal      func([]string)
+	onRTC           func(time.Time)
+	onAppConnection func(bool)
+	onOTA           func(string)
+	switchOn        string
+	switchOff       string
+	reliableRetries int
+	reliableTimeout time.Duration
+	unacked         map[uint16]*unackedMsg
+	subscribers     map[int][]chan []string
+	cmdStats        map[BlynkCommand]*CmdStat
+	connected       bool
+	onConnect       func()
+	offline         []offlineMsg
+	offlineMax      int
+	caps            ServerCapabilities
+	clients         int
+	clientsKnown    bool
+	waiters         map[ResponseKey][]chan *BlynkRespose
+	matcher         ResponseMatcher
+	maxLifetime     time.Duration
+	connectedAt     time.Time
+	reconnectReq    bool
+	reauthPending   bool
+	onReauth        func()
+	tokenProvider   func() (string, error)
+	heartbeatReset  chan bool
+	onHeartbeat     func(time.Duration)
+	fireAndForget   bool
+	heartbeatHook   func() (int, string)
+	highWater       int
+	aboveHighWater  bool
+	onBackpressure  func(int)
+	msgIDSource     func() uint16
+	timeouts        Timeouts
+	ready           chan struct{}
+	insecureSkip    bool
+	handlerWorkers  int
+	handlerQueues   []chan func()
+	onEvent         func(Event)
+	pausedAt        time.Time
+	history         []MessageLog
+	historySize     int
+	historyNext     int
+	historyFull     bool
+	autoFlush       time.Duration
+	flushBuf        []pinWrite
+	flushTimer      *time.Timer
+	deviceName      string
+	internalDirty   bool
+	debug           bool
+	onlineWait      chan struct{}
+	watchdogStop    chan struct{}
+	maxIncoming     int
+	dryRun          bool
+	retain          bool
+	lastReceived    map[int]string
+	unhandled       map[int]bool
+	unixSocket      string
+	tweetNoMedia    bool
+	syncOnConnect   bool
+	syncOnReconnect bool
+	restored        bool
+	notifyRate      time.Duration
+	notifyPolicy    NotifyPolicy
+	lastNotify      time.Time
+	notifyPending   string
+	notifyTimer     *time.Timer
+	serverOffset    time.Duration
+	backoff         func(int) time.Duration
+	sentMeter       rateMeter
+	recvMeter       rateMeter
+	connCtx         context.Context
+	connCancel      context.CancelFunc
+	serverName      string
+	draining        bool
+	decompress      bool
+	readyOnce       sync.Once
+}
+
+type readListener struct {
+	id int
+	fn func(*BlynkRespose)
 }
 
 func NewBlynk(APIkey string) *Blynk {
@@ -48,12 +164,29 @@ func NewBlynk(APIkey string) *Blynk {
 		heartbeat:       time.Second * 10,
 		timeout:         time.Millisecond * 50,
 		timeoutMAX:      time.Second * 5,
+		syncOnReconnect: true,
 		lock:            sync.Mutex{},
 		ssl:             true,
+		noDelay:         true,
 		cancel:          make(chan bool, 1),
 		writers:         make(map[uint]func(uint, io.Reader)),
 		readers:         make(map[uint]func(uint, io.Writer)),
 		recvMsg:         make(chan []byte, 10),
+		debounces:       make(map[int]*debounce),
+		lastWritten:     make(map[int]string),
+		streams:         make(map[string]int),
+		switchOn:        "1",
+		switchOff:       "0",
+		unacked:         make(map[uint16]*unackedMsg),
+		subscribers:     make(map[int][]chan []string),
+		cmdStats:        make(map[BlynkCommand]*CmdStat),
+		caps:            defaultCapabilities(),
+		waiters:         make(map[ResponseKey][]chan *BlynkRespose),
+		matcher:         MatchResponse,
+		heartbeatReset:  make(chan bool, 1),
+		timeouts:        defaultTimeouts,
+		ready:           make(chan struct{}),
+		historySize:     defaultHistorySize,
 	}
 }
 
@@ -66,16 +199,102 @@ func (g *Blynk) SetUseSSL(ssl bool) {
 	}
 }
 
+// SetInsecureSkipVerify disables verification of the server certificate,
+// meant only for development against self-signed servers.
+func (g *Blynk) SetInsecureSkipVerify(skip bool) {
+	g.insecureSkip = skip
+	if skip {
+		slog.Printf("[ERROR] SetInsecureSkipVerify: TLS certificate verification disabled, connection is open to MITM")
+	}
+}
+
 func (g *Blynk) SetServer(Server string, Port int, SSL bool) {
 	g.server = Server
 	g.port = Port
 	g.ssl = SSL
 }
 
+// SetServerName sets the host name sent as SNI and verified against the
+// server certificate, by default the server passed to SetServer. Together
+// with SetCAProvider it allows dialing the server by IP:
+//
+//	app.SetServer("203.0.113.10", 443, true)
+//	app.SetServerName("blynk-cloud.com")
+//	app.SetCAProvider(func() ([]byte, error) { return os.ReadFile("ca.pem") })
+func (g *Blynk) SetServerName(name string) {
+	g.serverName = name
+}
+
+func (g *Blynk) tlsServerName() string {
+	if g.serverName != "" {
+		return g.serverName
+	}
+	return g.server
+}
+
+// SetUnixSocket makes Connect dial the unix domain socket at path without
+// TLS instead of the server, an empty path switches back to the server.
+func (g *Blynk) SetUnixSocket(path string) {
+	g.unixSocket = path
+}
+
+// SetFallbackToPlain makes Connect retry over plain TCP on port 80 when the
+// TLS connection could not be made: the dial failed or timed out, or the
+// endpoint does not speak TLS. A certificate failing verification or an
+// unusable CA never falls back, the token would go out in clear text to
+// whoever presented it.
+func (g *Blynk) SetFallbackToPlain(state bool) {
+	g.fallbackPlain = state
+}
+
+// plainFallbackAllowed reports whether err from dialTLS is a connection
+// failure SetFallbackToPlain may recover from.
+func plainFallbackAllowed(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &verifyErr) || errors.As(err, &authorityErr) || errors.As(err, &hostErr) || errors.As(err, &invalidErr) {
+		return false
+	}
+	var headerErr tls.RecordHeaderError
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &headerErr) || errors.As(err, &opErr) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (g *Blynk) SetTCPKeepAlive(d time.Duration) {
+	g.tcpKeepAlive = d
+	if g.conn != nil {
+		g.applyTCPOptions()
+	}
+}
+
+func (g *Blynk) SetNoDelay(state bool) {
+	g.noDelay = state
+	if g.conn != nil {
+		g.applyTCPOptions()
+	}
+}
+
+// SetReadOnly turns the client into an observer, every write, notify, tweet
+// and email fails with ErrReadOnly while pings and reads keep working.
+func (g *Blynk) SetReadOnly(state bool) {
+	g.readOnly = state
+}
+
 func (g *Blynk) SetDebug() {
+	g.debug = true
 	slog.SetOptions(slog.SetDebug)
 }
 
+// SetOutput sends the logo and the logs of every client to w. The logger
+// writes through the standard log package, so this is process-wide and the
+// output of the log package is redirected as well.
+func SetOutput(w io.Writer) {
+	slog.GetDafault().SetOutput(w)
+}
+
 func (g *Blynk) DisableLogo(state bool) {
 	g.disableLogo = state
 }
@@ -103,6 +322,18 @@ func (g *Blynk) AddReaderHandler(pin uint, fn func(pin uint, writer io.Writer))
 	g.readers[pin] = fn
 }
 
+func (g *Blynk) AddReaderHandlerRange(start, end uint, fn func(pin uint, writer io.Writer)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for pin := start; pin <= end; pin++ {
+		g.readers[pin] = fn
+		if pin == end {
+			// pin++ would wrap around for the largest pin
+			break
+		}
+	}
+}
+
 func (g *Blynk) DeleteReaderHandler(pin uint) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
@@ -111,8 +342,21 @@ func (g *Blynk) DeleteReaderHandler(pin uint) {
 
 func (g *Blynk) AddWriterHandler(pin uint, fn func(pin uint, reader io.Reader)) {
 	g.lock.Lock()
-	defer g.lock.Unlock()
 	g.writers[pin] = fn
+	g.lock.Unlock()
+	g.replayRetained()
+}
+
+func (g *Blynk) AddWriterHandlerRange(start, end uint, fn func(pin uint, reader io.Reader)) {
+	g.lock.Lock()
+	for pin := start; pin <= end; pin++ {
+		g.writers[pin] = fn
+		if pin == end {
+			break
+		}
+	}
+	g.lock.Unlock()
+	g.replayRetained()
 }
 
 func (g *Blynk) DeleteWriterHandler(pin uint) {
@@ -121,19 +365,78 @@ func (g *Blynk) DeleteWriterHandler(pin uint) {
 	delete(g.writers, pin)
 }
 
+func (g *Blynk) AddReadListener(fn func(*BlynkRespose)) (cancel func()) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.listenerID++
+	id := g.listenerID
+	g.listeners = append(g.listeners, readListener{id: id, fn: fn})
+	return func() {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		for i, l := range g.listeners {
+			if l.id == id {
+				g.listeners = append(g.listeners[:i:i], g.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (g *Blynk) notifyReadListeners(resp *BlynkRespose) {
+	if g.OnReadFunc != nil {
+		g.OnReadFunc(resp)
+	}
+	g.lock.Lock()
+	listeners := g.listeners
+	g.lock.Unlock()
+	for _, l := range listeners {
+		l.fn(resp)
+	}
+}
+
+func (g *Blynk) OnSend(fn func(m BlynkMessage)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onSend = fn
+}
+
 func (g *Blynk) Connect() error {
 
 	g.printLogo()
 
+	return g.connect()
+}
+
+func (g *Blynk) connect() error {
+	if g.unixSocket != "" {
+		return g.connectUnix()
+	}
 	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", g.server, g.port))
 	if err != nil {
 		return err
 	}
 
-	if g.ssl {
-		g.conn, err = g.dialTLS(addr)
-	} else {
-		g.conn, err = net.DialTCP("tcp", nil, addr)
+	ssl := g.ssl
+	if ssl {
+		var conn *tls.Conn
+		if conn, err = g.dialTLS(addr); err == nil {
+			g.conn = conn
+		} else if g.fallbackPlain && plainFallbackAllowed(err) {
+			slog.Printf("[ERROR] Connect: TLS failed, %s, falling back to plain TCP", err.Error())
+			ssl = false
+			addr, err = net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", g.server, 80))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !ssl {
+		var conn net.Conn
+		dialer := net.Dialer{Timeout: g.getTimeouts().Connect}
+		if conn, err = dialer.Dial("tcp", addr.String()); err == nil {
+			g.conn = conn
+		}
 	}
 
 	if err != nil {
@@ -141,12 +444,66 @@ func (g *Blynk) Connect() error {
 	}
 	//defer conn.Close()
 
-	if err = g.auth(); err != nil {
+	g.applyTCPOptions()
+	if err = g.handshake(); err != nil {
 		return err
 	}
-	slog.Printf("Connect: Auth success (SSL: %v)", g.ssl)
+	slog.Printf("Connect: Auth success (SSL: %v)", ssl)
+	return nil
+}
 
-	g.sendInternal()
+func (g *Blynk) connectUnix() error {
+	dialer := net.Dialer{Timeout: g.getTimeouts().Connect}
+	conn, err := dialer.Dial("unix", g.unixSocket)
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+	if err = g.handshake(); err != nil {
+		return err
+	}
+	slog.Printf("Connect: Auth success (unix socket %s)", g.unixSocket)
+	return nil
+}
+
+// ConnectConn uses an already established connection, e.g. a tunnel or one
+// side of net.Pipe, instead of dialing the server. Reconnects still dial the
+// configured server.
+func (g *Blynk) ConnectConn(conn net.Conn) error {
+	if conn == nil {
+		return fmt.Errorf("ConnectConn: conn is nil")
+	}
+	g.printLogo()
+	g.conn = conn
+	g.applyTCPOptions()
+	return g.handshake()
+}
+
+// handshake authenticates the fresh g.conn and exchanges the hardware info.
+func (g *Blynk) handshake() error {
+	g.setConnected(true)
+
+	if err := g.auth(); err != nil {
+		g.setConnected(false)
+		g.emitEvent(EventAuthFailed, err)
+		return err
+	}
+
+	if err := g.sendInternal(); errors.Is(err, ErrProtocolMismatch) {
+		g.setConnected(false)
+		return err
+	} else if err != nil {
+		slog.Printf("[ERROR] Connect: %s", err.Error())
+	} else {
+		g.lock.Lock()
+		g.internalDirty = false
+		g.lock.Unlock()
+	}
+
+	g.markConnected()
+	g.startConnContext()
+	g.afterConnect()
+	g.emitEvent(EventConnected, nil)
 	return nil
 }
 
@@ -163,41 +520,208 @@ func (g *Blynk) dialTLS(addr *net.TCPAddr) (*tls.Conn, error) {
 
 	//w := os.Stdout
 	conf := tls.Config{
-		InsecureSkipVerify:     false,
+		InsecureSkipVerify:     g.insecureSkip,
 		MinVersion:             tls.VersionTLS12,
 		RootCAs:                roots,
-		ServerName:             g.server,
+		ServerName:             g.tlsServerName(),
 		SessionTicketsDisabled: true,
+		Certificates:           g.clientCerts,
 		//KeyLogWriter:           w,
 	}
-	conn, err := tls.Dial("tcp", addr.String(), &conf)
-	return conn, err
+	if g.insecureSkip {
+		slog.Printf("[ERROR] dialTLS: server certificate verification is DISABLED, do not use in production")
+	}
+	timeouts := g.getTimeouts()
+	dialer := net.Dialer{Timeout: timeouts.Connect}
+	raw, err := dialer.Dial("tcp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeouts.Handshake)
+	defer cancel()
+	conn := tls.Client(raw, &conf)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// RemoteAddr returns the address of the server end of the connection, nil
+// when not connected.
+func (g *Blynk) RemoteAddr() net.Addr {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.conn == nil || !g.connected {
+		return nil
+	}
+	return g.conn.RemoteAddr()
+}
+
+// LocalAddr returns the local address of the connection, nil when not
+// connected.
+func (g *Blynk) LocalAddr() net.Addr {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.conn == nil || !g.connected {
+		return nil
+	}
+	return g.conn.LocalAddr()
+}
+
+func (g *Blynk) tcpConn() *net.TCPConn {
+	return tcpConnOf(g.conn)
+}
+
+// tcpConnOf returns the TCP connection under conn, nil for other transports.
+func tcpConnOf(conn net.Conn) *net.TCPConn {
+	switch conn := conn.(type) {
+	case *net.TCPConn:
+		return conn
+	case *tls.Conn:
+		tcp, _ := conn.NetConn().(*net.TCPConn)
+		return tcp
+	}
+	return nil
+}
+
+func (g *Blynk) applyTCPOptions() {
+	tcp := g.tcpConn()
+	if tcp == nil {
+		return
+	}
+	if err := tcp.SetNoDelay(g.noDelay); err != nil {
+		slog.Printf("[ERROR] SetNoDelay failed, %s", err.Error())
+	}
+	if g.tcpKeepAlive > 0 {
+		if err := tcp.SetKeepAlive(true); err != nil {
+			slog.Printf("[ERROR] SetKeepAlive failed, %s", err.Error())
+		}
+		if err := tcp.SetKeepAlivePeriod(g.tcpKeepAlive); err != nil {
+			slog.Printf("[ERROR] SetKeepAlivePeriod failed, %s", err.Error())
+		}
+	}
+}
+
+// SetCAProvider sets a function returning the PEM encoded root CA used to
+// verify the server, it is called on every TLS connect. Without a provider
+// the embedded certs.CertServer is used.
+func (g *Blynk) SetCAProvider(fn func() ([]byte, error)) {
+	g.caProvider = fn
+}
+
+func (g *Blynk) SetClientCertificate(cert tls.Certificate) {
+	g.clientCerts = []tls.Certificate{cert}
+}
+
+func (g *Blynk) LoadClientCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("LoadClientCertificate: %w", err)
+	}
+	g.SetClientCertificate(cert)
+	return nil
 }
 
 func (g *Blynk) loadCA() ([]byte, error) {
+	if g.caProvider != nil {
+		return g.caProvider()
+	}
 	return []byte(certs.CertServer), nil
 }
 
 func (g *Blynk) Processing() {
 	g.processingUsing = true
 	defer func() { g.processingUsing = false }()
+	g.startHandlerWorkers()
 	go g.keepAlive()
 	go g.processor()
-	g.receiver()
+	g.readyOnce.Do(func() { close(g.ready) })
+	for {
+		err := g.receiver()
+		if err != nil {
+			g.setConnected(false)
+			g.emitEvent(EventDisconnected, err)
+		}
+		if wait := g.offlineWait(); wait != nil && err != nil {
+			select {
+			case <-wait:
+			case <-g.cancel:
+				return
+			}
+			g.takeReconnectRequest()
+		} else if err == nil || !(g.takeReconnectRequest() || g.autoReconnect) {
+			return
+		}
+		if err = g.reconnect(); err != nil {
+			slog.Printf("[ERROR] Processing: reconnect failed, %s", err.Error())
+			return
+		}
+		g.fireReauth()
+	}
+}
+
+// Ready returns a channel closed once Processing has started its
+// goroutines and the connection is being served.
+func (g *Blynk) Ready() <-chan struct{} {
+	return g.ready
+}
+
+// SetMessageIDSource replaces the message id counter, e.g. to get
+// deterministic frames in tests. A nil fn restores the counter.
+func (g *Blynk) SetMessageIDSource(fn func() uint16) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.msgIDSource = fn
 }
 
 func (g *Blynk) getMessageID() uint16 {
+	g.lock.Lock()
+	source := g.msgIDSource
+	if source == nil {
+		g.msgID++
+		if g.msgID == 0 {
+			g.msgID = 1
+		}
+		id := g.msgID
+		g.lock.Unlock()
+		return id
+	}
+	g.lock.Unlock()
+	// called without the lock, the source may use the client itself
+	return source()
+}
+
+// SetTokenProvider sets a function called on every connect and reconnect to
+// get the current token, APIkey is used when it is not set.
+func (g *Blynk) SetTokenProvider(fn func() (string, error)) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
-	g.msgID++
-	if g.msgID > 0xFFFF {
-		g.msgID = 1
+	g.tokenProvider = fn
+}
+
+func (g *Blynk) token() (string, error) {
+	g.lock.Lock()
+	provider, key := g.tokenProvider, g.APIkey
+	g.lock.Unlock()
+	if provider == nil {
+		return key, nil
 	}
-	return g.msgID
+	token, err := provider()
+	if err != nil {
+		return "", fmt.Errorf("auth: token provider failed, %w", err)
+	}
+	return token, nil
 }
 
 func (g *Blynk) auth() error {
-	_, err := g.sendString(BLYNK_CMD_HW_LOGIN, g.APIkey)
+	token, err := g.token()
+	if err != nil {
+		return err
+	}
+
+	id, err := g.sendString(BLYNK_CMD_HW_LOGIN, token)
 	if err != nil {
 		return err
 	}
@@ -207,44 +731,192 @@ func (g *Blynk) auth() error {
 		return err
 	}
 
-	if response != nil && (response.MessageId != g.msgID || response.Command != BLYNK_CMD_RESPONSE || response.Length != BLYNK_SUCCESS) {
+	if response != nil && response.Length == BLYNK_NOT_SUPPORTED_VERSION {
+		return fmt.Errorf("auth: %w, client %s, server unknown", ErrProtocolMismatch, ProtocolVersion)
+	}
+
+	if response != nil && response.Command == BLYNK_CMD_RESPONSE && response.Length == BLYNK_INVALID_TOKEN {
+		return fmt.Errorf("auth: %w", statusError(BLYNK_CMD_HW_LOGIN, response.Length))
+	}
+
+	if response != nil && (response.MessageId != id || response.Command != BLYNK_CMD_RESPONSE || response.Length != BLYNK_SUCCESS) {
 		return fmt.Errorf("auth: failed, message id-%d, code-%d", response.MessageId, response.Length)
 	}
 	return nil
 }
 
 func (g *Blynk) sendInternal() error {
-	if _, err := g.sendString(BLYNK_CMD_INTERNAL, g.formatInternal()); err != nil {
+	id, err := g.sendString(BLYNK_CMD_INTERNAL, g.formatInternal())
+	if err != nil {
 		return err
 	}
 
-	resp, err := g.receiveMessage(g.timeoutMAX)
-	if err != nil {
+	// the server may send its hints before or after the response
+	var hints []*BlynkRespose
+	collect := func(resp *BlynkRespose) bool {
+		if resp.Command != BLYNK_CMD_INTERNAL {
+			return false
+		}
+		hints = append(hints, resp)
+		return true
+	}
+	resp, err := g.awaitResponse(time.Now().Add(g.timeoutMAX), id, collect)
+	switch {
+	case err != nil && !(errors.Is(err, ErrTimeout) && len(hints) > 0):
 		return err
+	case err == nil && resp.Status == BLYNK_NOT_SUPPORTED_VERSION:
+		return fmt.Errorf("sendInternal: %w, client %s, server unknown", ErrProtocolMismatch, ProtocolVersion)
+	case err == nil && resp.Status != BLYNK_SUCCESS:
+		return fmt.Errorf("sendInternal: received unsuccessful code %d", resp.Status)
+	}
+	if err == nil {
+		g.drainInline(g.timeout, collect)
 	}
 
-	if resp.Length != BLYNK_SUCCESS {
-		return fmt.Errorf("sendInternal: received unsuccessful code %d", resp.Length)
+	for _, hint := range hints {
+		if err := checkProtocolVersion(hint.Values); err != nil {
+			return err
+		}
+		g.parseCapabilities(hint.Values)
+		g.applyServerHeartbeat(hint.Values)
 	}
+	return nil
+}
 
+// checkProtocolVersion compares the major version of the server "ver" hint
+// with ProtocolVersion. The "ver" the client sends is Version, the two
+// fields share a name but not a meaning.
+func checkProtocolVersion(fields []string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] != "ver" {
+			continue
+		}
+		server := fields[i+1]
+		if strings.SplitN(server, ".", 2)[0] != strings.SplitN(ProtocolVersion, ".", 2)[0] {
+			return fmt.Errorf("sendInternal: %w, client %s, server %s", ErrProtocolMismatch, ProtocolVersion, server)
+		}
+	}
 	return nil
 }
 
+// SendInternalInfo re-sends the hardware info (version, heartbeat) so the
+// server picks up values changed at runtime without a reconnect.
+func (g *Blynk) SendInternalInfo() error {
+	if !g.processingUsing {
+		return g.sendInternal()
+	}
+
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_INTERNAL
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString(g.formatInternal())
+	msg.Head.Length = msg.Body.Len()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	return g.requestStatus(ctx, msg)
+}
+
+// formatInternal builds the hardware info body, "ver" carries the library
+// Version, see checkProtocolVersion.
 func (g *Blynk) formatInternal() string {
-	rcv_buffer := "1024"
-	params := []string{"ver", Version, "buff-in", rcv_buffer, "h-beat", fmt.Sprintf("%.0f", g.heartbeat.Seconds()), "dev", "go"}
-	return strings.Join(params, string(0x00))
+	rcv_buffer := strconv.Itoa(receiveBufferSize)
+	params := []string{"ver", Version, "buff-in", rcv_buffer, "h-beat", fmt.Sprintf("%.0f", g.getHeartbeat().Seconds()), "dev", "go"}
+	g.lock.Lock()
+	if g.deviceName != "" {
+		params = append(params, "name", g.deviceName)
+	}
+	g.lock.Unlock()
+	return strings.Join(params, "\x00")
+}
+
+// SetDeviceName sets the name advertised in the hardware info, a live
+// session picks it up with RefreshInternal.
+func (g *Blynk) SetDeviceName(name string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.deviceName = name
+	g.internalDirty = true
+}
+
+// RefreshInternal re-sends the hardware info if a setter changed it since
+// it was last sent.
+func (g *Blynk) RefreshInternal() error {
+	g.lock.Lock()
+	dirty := g.internalDirty
+	g.internalDirty = false
+	g.lock.Unlock()
+	if !dirty {
+		return nil
+	}
+	if err := g.SendInternalInfo(); err != nil {
+		g.lock.Lock()
+		g.internalDirty = true
+		g.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (g *Blynk) SetDiagnostics(fn func() map[string]string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.diagnostics = fn
+}
+
+func (g *Blynk) sendDiagnostics() error {
+	g.lock.Lock()
+	fn := g.diagnostics
+	g.lock.Unlock()
+	if fn == nil {
+		return nil
+	}
+
+	fields := fn()
+	if len(fields) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_INTERNAL
+	msg.Head.MessageId = g.getMessageID()
+	for _, k := range keys {
+		msg.Body.AddString(k)
+		msg.Body.AddString(fields[k])
+	}
+	msg.Head.Length = msg.Body.Len()
+
+	if _, err := g.sendMessage(msg); err != nil {
+		slog.Printf("[ERROR] sendDiagnostics: %s", err.Error())
+		return err
+	}
+	return nil
 }
 
 func (g *Blynk) keepAlive() {
 	slog.Printf("Keep-Alive: started")
 	defer slog.Printf("Keep-Alive: finished")
-	t := time.NewTicker(g.heartbeat)
+	t := time.NewTicker(g.getHeartbeat())
 	for {
 		select {
 		case <-t.C:
+			if g.keepAlivePaused() {
+				break
+			}
 			slog.Printf("[DEBUG] Keep-Alive: send")
-			g.sendCommand(BLYNK_CMD_PING)
+			if id, err := g.sendCommand(BLYNK_CMD_PING); err == nil {
+				g.pingSent(id)
+			}
+			g.sendDiagnostics()
+			g.sendHeartbeatHook()
+			g.checkLifetime()
+		case <-g.heartbeatReset:
+			t.Reset(g.getHeartbeat())
 		case <-g.cancel:
 			slog.Printf("[DEBUG] Keep-Alive: Stop received")
 			t.Stop()
@@ -254,12 +926,130 @@ func (g *Blynk) keepAlive() {
 }
 
 func (g *Blynk) VirtualWrite(pin int, value string) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	if g.debounceWrite(pin, value) {
+		return nil
+	}
+	if g.bufferWrite(pin, value) {
+		return nil
+	}
+	return g.virtualWrite(pin, value)
+}
+
+func (g *Blynk) virtualWrite(pin int, value string) error {
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_HARDWARE
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("vw")
+	msg.Body.AddInt(pin)
+	msg.Body.AddString(value)
+	msg.Head.Length = msg.Body.Len()
+
+	if _, err := g.sendMessage(msg); err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	g.lastWritten[pin] = value
+	g.lock.Unlock()
+	return nil
+}
+
+func (g *Blynk) LastWritten(pin int) (string, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	value, ok := g.lastWritten[pin]
+	return value, ok
+}
+
+func (g *Blynk) SetSwitchFormat(onVal, offVal string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.switchOn = onVal
+	g.switchOff = offVal
+}
+
+func (g *Blynk) VirtualWriteSwitch(pin int, on bool) error {
+	g.lock.Lock()
+	value := g.switchOff
+	if on {
+		value = g.switchOn
+	}
+	g.lock.Unlock()
+	return g.VirtualWrite(pin, value)
+}
+
+func (g *Blynk) MapStream(name string, pin int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.streams[name] = pin
+}
+
+func (g *Blynk) VirtualWriteStream(streamName string, value string) error {
+	g.lock.Lock()
+	pin, ok := g.streams[streamName]
+	g.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("VirtualWriteStream: stream %q is not mapped to a pin", streamName)
+	}
+	return g.VirtualWrite(pin, value)
+}
+
+// VirtualWriteBatch sends one hardware message per pin, in pin order, with a
+// single write on the connection so the server receives them together.
+func (g *Blynk) VirtualWriteBatch(values map[int]string) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	pins := make([]int, 0, len(values))
+	for pin := range values {
+		pins = append(pins, pin)
+	}
+	sort.Ints(pins)
+
+	msgs := make([]BlynkMessage, len(pins))
+	for i, pin := range pins {
+		msgs[i].Head.Command = BLYNK_CMD_HARDWARE
+		msgs[i].Head.MessageId = g.getMessageID()
+		msgs[i].Body.AddString("vw")
+		msgs[i].Body.AddInt(pin)
+		msgs[i].Body.AddString(values[pin])
+		msgs[i].Head.Length = msgs[i].Body.Len()
+	}
+
+	if err := g.sendMessages(msgs...); err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	for _, pin := range pins {
+		g.lastWritten[pin] = values[pin]
+	}
+	g.lock.Unlock()
+	return nil
+}
+
+// VirtualWriteAt sends the value with its unix timestamp in milliseconds
+// appended, so the server stores the point at t rather than at arrival.
+func (g *Blynk) VirtualWriteAt(pin int, value string, t time.Time) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
 	msg := BlynkMessage{}
 	msg.Head.Command = BLYNK_CMD_HARDWARE
 	msg.Head.MessageId = g.getMessageID()
 	msg.Body.AddString("vw")
 	msg.Body.AddInt(pin)
 	msg.Body.AddString(value)
+	msg.Body.AddString(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))
 	msg.Head.Length = msg.Body.Len()
 
 	if _, err := g.sendMessage(msg); err != nil {
@@ -268,6 +1058,15 @@ func (g *Blynk) VirtualWrite(pin int, value string) error {
 	return nil
 }
 
+// VirtualWriteJSON sends v encoded with json.Marshal as the pin value.
+func (g *Blynk) VirtualWriteJSON(pin int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("VirtualWriteJSON: %w", err)
+	}
+	return g.VirtualWrite(pin, string(data))
+}
+
 func (g *Blynk) VirtualRead(pins ...int) error {
 	msg := BlynkMessage{}
 	msg.Head.Command = BLYNK_CMD_HARDWARE_SYNC
@@ -284,6 +1083,9 @@ func (g *Blynk) VirtualRead(pins ...int) error {
 }
 
 func (g *Blynk) DigitalWrite(pin int, value bool) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
 	msg := BlynkMessage{}
 	msg.Head.Command = BLYNK_CMD_HARDWARE
 	msg.Head.MessageId = g.getMessageID()
@@ -313,50 +1115,139 @@ func (g *Blynk) DigitalRead(pin int) error {
 	return nil
 }
 
-func (g *Blynk) Notify(msg string) error {
-	_, err := g.sendString(BLYNK_CMD_NOTIFY, msg)
-	if err != nil {
-		return fmt.Errorf("send notify failed, %s", err.Error())
+func (g *Blynk) AnalogWrite(pin int, value int) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	if value < 0 || value > 255 {
+		return fmt.Errorf("AnalogWrite: value %d is out of range 0-255", value)
 	}
 
-	//if receiver is using dont use standalone receive func
-	if g.processingUsing {
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_HARDWARE
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("aw")
+	msg.Body.AddInt(pin)
+	msg.Body.AddInt(value)
+	msg.Head.Length = msg.Body.Len()
+
+	if _, err := g.sendMessage(msg); err != nil {
 		return err
 	}
+	return nil
+}
 
-	bh, err := g.receiveMessage(time.Duration(time.Second * 5))
-	if err != nil {
+func (g *Blynk) AnalogRead(pin int) error {
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_HARDWARE_SYNC
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("ar")
+	msg.Body.AddInt(pin)
+	msg.Head.Length = msg.Body.Len()
+
+	if _, err := g.sendMessage(msg); err != nil {
 		return err
 	}
-	if bh.Length != BLYNK_SUCCESS {
-		return fmt.Errorf("notify failed, cause: %s (%d)", GetBlynkStatus(bh.Length), bh.Length)
-	}
 
 	return nil
 }
 
-func (g *Blynk) Tweet(msg string) error {
-	_, err := g.sendString(BLYNK_CMD_TWEET, msg)
-	if err != nil {
-		return fmt.Errorf("send tweet failed, %s", err.Error())
+func (g *Blynk) Notify(msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	return g.NotifyContext(ctx, msg)
+}
+
+func (g *Blynk) NotifyContext(ctx context.Context, msg string) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
 	}
+	if throttled, err := g.throttleNotify(msg); throttled {
+		return err
+	}
+	return g.notify(ctx, msg)
+}
+
+func (g *Blynk) notify(ctx context.Context, msg string) error {
+	bmsg := BlynkMessage{}
+	bmsg.Head.Command = BLYNK_CMD_NOTIFY
+	bmsg.Head.MessageId = g.getMessageID()
+	bmsg.Body.AddString(msg)
+	bmsg.Head.Length = bmsg.Body.Len()
 
-	if g.processingUsing {
+	return g.sendNotification(ctx, bmsg)
+}
+
+func (g *Blynk) Tweet(msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	return g.TweetContext(ctx, msg)
+}
+
+func (g *Blynk) TweetContext(ctx context.Context, msg string) error {
+	if err := g.acceptingWrites(); err != nil {
 		return err
 	}
+	bmsg := BlynkMessage{}
+	bmsg.Head.Command = BLYNK_CMD_TWEET
+	bmsg.Head.MessageId = g.getMessageID()
+	bmsg.Body.AddString(msg)
+	bmsg.Head.Length = bmsg.Body.Len()
 
-	bh, err := g.receiveMessage(time.Duration(time.Second * 5))
-	if err != nil {
+	return g.sendNotification(ctx, bmsg)
+}
+
+func (g *Blynk) EMail(to string, subject string, msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	return g.EMailContext(ctx, to, subject, msg)
+}
+
+func (g *Blynk) EMailContext(ctx context.Context, to string, subject string, msg string) error {
+	if err := g.acceptingWrites(); err != nil {
 		return err
 	}
-	if bh.Length != BLYNK_SUCCESS {
-		return fmt.Errorf("tweet failed, cause: %s (%d)", GetBlynkStatus(bh.Length), bh.Length)
+	if err := checkEMail(to); err != nil {
+		return err
 	}
 
+	bmsg := BlynkMessage{}
+	bmsg.Head.MessageId = g.getMessageID()
+	bmsg.Head.Command = BLYNK_CMD_EMAIL
+	bmsg.Body.AddString(to)
+	bmsg.Body.AddString(subject)
+	bmsg.Body.AddString(msg)
+	bmsg.Head.Length = bmsg.Body.Len()
+
+	return g.sendNotification(ctx, bmsg)
+}
+
+// checkEMail validates the recipient, one address or a comma separated list.
+func checkEMail(to string) error {
+	if strings.TrimSpace(to) == "" {
+		return fmt.Errorf("%w, no recipient", ErrInvalidEmail)
+	}
+	if _, err := mail.ParseAddressList(to); err != nil {
+		return fmt.Errorf("%w %q, %s", ErrInvalidEmail, to, err.Error())
+	}
 	return nil
 }
 
-func (g *Blynk) EMail(to string, subject string, msg string) error {
+// EMailAsync sends the email and delivers the server answer on the returned
+// channel, so many emails can be in flight while Processing runs. Without
+// Processing the channel reports ErrNotConnected, with SetFireAndForget it
+// reports the write result and in a dry run success.
+func (g *Blynk) EMailAsync(to, subject, msg string) <-chan error {
+	if err := g.acceptingWrites(); err != nil {
+		result := make(chan error, 1)
+		result <- err
+		return result
+	}
+	if err := checkEMail(to); err != nil {
+		result := make(chan error, 1)
+		result <- err
+		return result
+	}
 
 	bmsg := BlynkMessage{}
 	bmsg.Head.MessageId = g.getMessageID()
@@ -366,40 +1257,211 @@ func (g *Blynk) EMail(to string, subject string, msg string) error {
 	bmsg.Body.AddString(msg)
 	bmsg.Head.Length = bmsg.Body.Len()
 
-	_, err := g.sendMessage(bmsg)
+	if g.fireAndForget {
+		result := make(chan error, 1)
+		_, err := g.sendMessage(bmsg)
+		result <- err
+		return result
+	}
+	return g.requestStatusAsync(bmsg, g.getTimeouts().Read)
+}
+
+// SetFireAndForget makes Notify, Tweet and EMail return as soon as the message
+// is written instead of waiting for the server answer.
+func (g *Blynk) SetFireAndForget(state bool) {
+	g.fireAndForget = state
+}
 
-	//if receiver is using dont use standalone receive func
-	if g.processingUsing {
+// TweetWithMedia tweets msg with mediaURL sent as an extra body field. A
+// server rejecting the field gets, from then on, a plain tweet with the URL
+// appended to the text.
+func (g *Blynk) TweetWithMedia(msg, mediaURL string) error {
+	if err := g.acceptingWrites(); err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	if mediaURL == "" {
+		return g.TweetContext(ctx, msg)
+	}
+
+	g.lock.Lock()
+	noMedia := g.tweetNoMedia
+	g.lock.Unlock()
+	if noMedia || g.fireAndForget {
+		return g.TweetContext(ctx, msg+" "+mediaURL)
+	}
+
+	bmsg := BlynkMessage{}
+	bmsg.Head.Command = BLYNK_CMD_TWEET
+	bmsg.Head.MessageId = g.getMessageID()
+	bmsg.Body.AddString(msg)
+	bmsg.Body.AddString(mediaURL)
+	bmsg.Head.Length = bmsg.Body.Len()
 
-	bh, err := g.receiveMessage(time.Duration(time.Second * 5))
+	resp, err := g.request(ctx, bmsg)
 	if err != nil {
 		return err
 	}
-	if bh.Length != BLYNK_SUCCESS {
-		return fmt.Errorf("email failed, cause: %s (%d)", GetBlynkStatus(bh.Length), bh.Length)
+	switch resp.Status {
+	case BLYNK_SUCCESS:
+		return nil
+	case BLYNK_ILLEGAL_COMMAND, BLYNK_NTF_INVALID_BODY:
+		slog.Printf("[INFO] TweetWithMedia: media field rejected, %s, sending plain tweets", GetBlynkStatus(resp.Status))
+		g.lock.Lock()
+		g.tweetNoMedia = true
+		g.lock.Unlock()
+		return g.TweetContext(ctx, msg+" "+mediaURL)
 	}
+	g.disableCapability(BLYNK_CMD_TWEET, resp.Status)
+	return statusError(BLYNK_CMD_TWEET, resp.Status)
+}
 
-	return nil
+func (g *Blynk) sendNotification(ctx context.Context, msg BlynkMessage) error {
+	if g.fireAndForget {
+		_, err := g.sendMessage(msg)
+		return err
+	}
+	return g.requestStatus(ctx, msg)
 }
 
+var _ io.Closer = (*Blynk)(nil)
+
 func (g *Blynk) Stop() error {
+	return g.shutdown()
+}
+
+func (g *Blynk) Close() error {
+	return g.shutdown()
+}
+
+// StopWithTimeout works like Stop but gives up after d, the underlying socket
+// is then closed without waiting for the TLS close or unsent data.
+func (g *Blynk) StopWithTimeout(d time.Duration) error {
+	if g == nil {
+		return fmt.Errorf("Blynk: source object blynk is nil")
+	}
+	// taken before shutdown starts replacing or closing it
+	g.lock.Lock()
+	conn := g.conn
+	g.lock.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- g.shutdown() }()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		slog.Printf("[ERROR] Stop: timed out after %s, forcing close", d)
+		if tcp := tcpConnOf(conn); tcp != nil {
+			tcp.SetLinger(0)
+			tcp.Close()
+		} else if conn != nil {
+			// unix sockets and ConnectConn transports
+			go conn.Close()
+		}
+		return ErrTimeout
+	}
+}
+
+// StopGracefully refuses new writes from the public write methods with
+// ErrClosed, sends the debounced and buffered ones, waits up to drainTimeout
+// for outstanding responses and for the processor to handle the queued
+// incoming messages, and stops. ErrTimeout is returned if the drain did not
+// finish, the client is stopped anyway.
+func (g *Blynk) StopGracefully(drainTimeout time.Duration) error {
+	if g == nil {
+		return fmt.Errorf("Blynk: source object blynk is nil")
+	}
+	g.lock.Lock()
+	g.draining = true
+	g.lock.Unlock()
+
+	g.flushDebounces()
+	g.flushWrites()
+
+	deadline := time.Now().Add(drainTimeout)
+	drained := true
+	for !g.isDrained() {
+		if time.Now().After(deadline) {
+			slog.Printf("[ERROR] Stop: drain timed out after %s", drainTimeout)
+			drained = false
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := g.shutdown(); err != nil {
+		return err
+	}
+	if !drained {
+		return ErrTimeout
+	}
+	return nil
+}
+
+func (g *Blynk) isDrained() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if len(g.unacked) > 0 || len(g.waiters) > 0 {
+		return false
+	}
+	return !g.processingUsing || len(g.recvMsg) == 0
+}
+
+// acceptingWrites guards the public write methods, after Stop and while
+// StopGracefully drains they fail with ErrClosed. Internal traffic like ping
+// and reader replies or resends goes on until the connection is closed.
+func (g *Blynk) acceptingWrites() error {
+	g.lock.Lock()
+	draining := g.draining
+	g.lock.Unlock()
+	if draining || g.isCancelled() {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (g *Blynk) isCancelled() bool {
+	select {
+	case <-g.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (g *Blynk) shutdown() error {
 	if g == nil {
 		return fmt.Errorf("Blynk: source object blynk is nil")
 	}
-	slog.Printf("[DEBUG] Sending to cancle channel")
-	g.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
-	close(g.cancel)
-	close(g.recvMsg)
-	time.Sleep(time.Second * 1)
-	return g.Disconnect()
+	var err error
+	g.stopOnce.Do(func() {
+		slog.Printf("[DEBUG] Sending to cancle channel")
+		g.flushWrites()
+		if g.conn != nil {
+			g.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+		}
+		close(g.cancel)
+		g.endConnContext()
+		if g.processingUsing {
+			time.Sleep(time.Second * 1)
+		}
+		if g.conn != nil {
+			err = g.Disconnect()
+		}
+	})
+	return err
 }
 
 func (g *Blynk) Disconnect() error {
 	if g == nil || g.conn == nil {
 		return fmt.Errorf("disconnect: *Blynk or *net.TCPConn is nil")
 	}
+	g.setConnected(false)
 	err := g.conn.Close()
 	return err
 }
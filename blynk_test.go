@@ -0,0 +1,89 @@
+package blynk
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// discardConn accepts every write, so a benchmark measures only the client.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func BenchmarkVirtualWrite(b *testing.B) {
+	g := NewBlynk("token")
+	g.DisableLogo(true)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	g.conn = discardConn{client}
+	g.setConnected(true)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := g.VirtualWrite(1, "42"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMessageIDSourceMayUseClient(t *testing.T) {
+	g := NewBlynk("token")
+	g.SetMessageIDSource(func() uint16 {
+		// would deadlock if called under the client lock
+		g.Capabilities()
+		return 42
+	})
+
+	done := make(chan uint16, 1)
+	go func() { done <- g.getMessageID() }()
+	select {
+	case id := <-done:
+		if id != 42 {
+			t.Errorf("id = %d, want 42", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("getMessageID deadlocked")
+	}
+}
+
+func TestStopWithTimeoutClosesAnyTransport(t *testing.T) {
+	g, server := newPipeClient(t)
+	// Processing makes shutdown wait longer than the timeout
+	g.processingUsing = true
+
+	if err := g.StopWithTimeout(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("StopWithTimeout = %v, want ErrTimeout", err)
+	}
+	server.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("server read = %v, want EOF of the closed connection", err)
+	}
+}
+
+func TestHandlerRangeEndingAtLargestPin(t *testing.T) {
+	g := NewBlynk("token")
+	last := ^uint(0)
+
+	done := make(chan struct{})
+	go func() {
+		g.AddReaderHandlerRange(last-1, last, func(uint, io.Writer) {})
+		g.AddWriterHandlerRange(last-1, last, func(uint, io.Reader) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("range ending at the largest pin does not terminate")
+	}
+	if len(g.readers) != 2 || len(g.writers) != 2 {
+		t.Errorf("handlers = %d readers, %d writers, want 2 each", len(g.readers), len(g.writers))
+	}
+}
@@ -0,0 +1,79 @@
+package blynk
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ServerCapabilities describes the optional features of the server. Legacy
+// servers do not advertise them, so notify, tweet and email are assumed
+// until the server either disables them in the handshake or rejects a call.
+type ServerCapabilities struct {
+	Notify bool
+	Tweet  bool
+	EMail  bool
+	Events bool
+}
+
+func defaultCapabilities() ServerCapabilities {
+	return ServerCapabilities{Notify: true, Tweet: true, EMail: true}
+}
+
+func (g *Blynk) Capabilities() ServerCapabilities {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.caps
+}
+
+// ConnectedClients returns the number of connections for the token as
+// reported by the server in the "clients" field of the internal handshake.
+// Servers which do not report it return ErrUnsupported.
+func (g *Blynk) ConnectedClients() (int, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.clientsKnown {
+		return 0, fmt.Errorf("connected clients: %w", ErrUnsupported)
+	}
+	return g.clients, nil
+}
+
+// parseCapabilities reads the key/value hints of the internal handshake
+// response, e.g. "email" "0".
+func (g *Blynk) parseCapabilities(fields []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i := 0; i+1 < len(fields); i += 2 {
+		state := fields[i+1] != "0"
+		switch fields[i] {
+		case "notify":
+			g.caps.Notify = state
+		case "tweet":
+			g.caps.Tweet = state
+		case "email":
+			g.caps.EMail = state
+		case "events":
+			g.caps.Events = state
+		case "clients":
+			if n, err := strconv.Atoi(fields[i+1]); err == nil {
+				g.clients = n
+				g.clientsKnown = true
+			}
+		}
+	}
+}
+
+func (g *Blynk) disableCapability(cmd BlynkCommand, status uint16) {
+	if status != BLYNK_ILLEGAL_COMMAND && status != BLYNK_NOT_ALLOWED {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch cmd {
+	case BLYNK_CMD_NOTIFY:
+		g.caps.Notify = false
+	case BLYNK_CMD_TWEET:
+		g.caps.Tweet = false
+	case BLYNK_CMD_EMAIL:
+		g.caps.EMail = false
+	}
+}
@@ -0,0 +1,85 @@
+package blynk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// compressedMarker prefixes values packed by VirtualWriteCompressed. The gzip
+// stream is base64 encoded because the body uses 0x00 as a field separator.
+const compressedMarker = "gzip:"
+
+// maxDecompressedSize caps an unpacked value, a small frame must not
+// inflate into an arbitrary amount of memory.
+const maxDecompressedSize = 64 << 10
+
+// SetDecompressValues unpacks incoming virtual pin values written by
+// VirtualWriteCompressed. It is off by default, a plain value starting with
+// the marker would be rewritten otherwise.
+func (g *Blynk) SetDecompressValues(state bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.decompress = state
+}
+
+// decompressResponse unpacks the values of a "vw" message if enabled.
+func (g *Blynk) decompressResponse(resp *BlynkRespose) {
+	g.lock.Lock()
+	enabled := g.decompress
+	g.lock.Unlock()
+	if !enabled || resp.Command != BLYNK_CMD_HARDWARE || len(resp.Values) < 3 || resp.Values[0] != "vw" {
+		return
+	}
+	for i := 2; i < len(resp.Values); i++ {
+		resp.Values[i], _ = decompressValue(resp.Values[i])
+	}
+}
+
+func (g *Blynk) VirtualWriteCompressed(pin int, data []byte) error {
+	value, err := compressValue(data)
+	if err != nil {
+		return err
+	}
+	return g.VirtualWrite(pin, value)
+}
+
+func compressValue(data []byte) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	packed := compressedMarker + base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(packed) >= len(data) {
+		return string(data), nil
+	}
+	return packed, nil
+}
+
+func decompressValue(value string) (string, bool) {
+	if !strings.HasPrefix(value, compressedMarker) {
+		return value, false
+	}
+	raw, err := base64.StdEncoding.DecodeString(value[len(compressedMarker):])
+	if err != nil {
+		return value, false
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return value, false
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(zr, maxDecompressedSize+1))
+	if err != nil || len(data) > maxDecompressedSize {
+		return value, false
+	}
+	return string(data), true
+}
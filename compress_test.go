@@ -0,0 +1,41 @@
+package blynk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestDecompressIsOptIn(t *testing.T) {
+	value, err := compressValue([]byte(strings.Repeat("sensor ", 50)))
+	if err != nil || !strings.HasPrefix(value, compressedMarker) {
+		t.Fatalf("compressValue = %q, %v", value, err)
+	}
+	buf := frame(BLYNK_CMD_HARDWARE, 1, "vw", "4", value)
+
+	g := NewBlynk("token")
+	resps, _ := g.parseResponce(buf)
+	if resps[0].Values[2] != value {
+		t.Errorf("value rewritten without SetDecompressValues")
+	}
+
+	g.SetDecompressValues(true)
+	resps, _ = g.parseResponce(buf)
+	if resps[0].Values[2] != strings.Repeat("sensor ", 50) {
+		t.Errorf("value not unpacked with SetDecompressValues")
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	var packed bytes.Buffer
+	zw := gzip.NewWriter(&packed)
+	zw.Write(make([]byte, maxDecompressedSize+1))
+	zw.Close()
+	value := compressedMarker + base64.StdEncoding.EncodeToString(packed.Bytes())
+
+	if _, ok := decompressValue(value); ok {
+		t.Error("value above maxDecompressedSize was unpacked")
+	}
+}
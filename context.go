@@ -0,0 +1,37 @@
+package blynk
+
+import "context"
+
+// Context returns a context of the current connection, it is cancelled when
+// the connection is lost, replaced by a reconnect or the client is stopped.
+// Handlers doing blocking work should watch it. Without a connection the
+// returned context is already cancelled.
+func (g *Blynk) Context() context.Context {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.connCtx == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	return g.connCtx
+}
+
+// startConnContext creates the context of a freshly established connection.
+func (g *Blynk) startConnContext() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.connCancel != nil {
+		g.connCancel()
+	}
+	g.connCtx, g.connCancel = context.WithCancel(context.Background())
+}
+
+func (g *Blynk) endConnContext() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.connCancel != nil {
+		g.connCancel()
+		g.connCancel = nil
+	}
+}
@@ -0,0 +1,273 @@
+package blynk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// request sends msg and waits for the response carrying the same message id.
+// While Processing runs the response is delivered by processor through the
+// waiter table, otherwise it is read from the connection directly.
+func (g *Blynk) request(ctx context.Context, msg BlynkMessage) (*BlynkRespose, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	id := msg.Head.MessageId
+
+	if !g.processingUsing {
+		logged, err := g.sendOrLog(msg)
+		if err != nil {
+			return nil, fmt.Errorf("send %s failed, %w", CommandName(msg.Head.Command), err)
+		}
+		if logged {
+			return dryRunResponse(id), nil
+		}
+		resp, err := g.receiveStatus(ctx, id)
+		if err == nil && resp.Status == BLYNK_NOT_AUTHENTICATED {
+			// processor handles this while Processing runs
+			g.handleNotAuthenticated()
+		}
+		return resp, err
+	}
+
+	ch := g.addWaiter(idKey(id))
+	defer g.removeWaiter(idKey(id), ch)
+
+	logged, err := g.sendOrLog(msg)
+	if err != nil {
+		return nil, fmt.Errorf("send %s failed, %w", CommandName(msg.Head.Command), err)
+	}
+	if logged {
+		return dryRunResponse(id), nil
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dryRunResponse answers a request a dry run only logged, as the server
+// would have accepted it.
+func dryRunResponse(id uint16) *BlynkRespose {
+	return &BlynkRespose{Command: BLYNK_CMD_RESPONSE, MessageId: id, Status: BLYNK_SUCCESS}
+}
+
+// requestStatusAsync sends msg right away and reports the server answer on the
+// returned channel. Without Processing nothing reads the answer, msg is not
+// sent and ErrNotConnected is reported.
+func (g *Blynk) requestStatusAsync(msg BlynkMessage, timeout time.Duration) <-chan error {
+	result := make(chan error, 1)
+	cmd, id := msg.Head.Command, msg.Head.MessageId
+	g.lock.Lock()
+	dryRun := g.dryRun
+	g.lock.Unlock()
+	if !g.processingUsing && !dryRun {
+		result <- fmt.Errorf("%s needs Processing to read the answer, %w", CommandName(cmd), ErrNotConnected)
+		return result
+	}
+
+	ch := g.addWaiter(idKey(id))
+	logged, err := g.sendOrLog(msg)
+	if err != nil || logged {
+		g.removeWaiter(idKey(id), ch)
+		if err != nil {
+			err = fmt.Errorf("send %s failed, %w", CommandName(cmd), err)
+		}
+		result <- err
+		return result
+	}
+
+	go func() {
+		defer g.removeWaiter(idKey(id), ch)
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case resp := <-ch:
+			if resp.Status != BLYNK_SUCCESS {
+				g.disableCapability(cmd, resp.Status)
+				result <- statusError(cmd, resp.Status)
+				return
+			}
+			result <- nil
+		case <-t.C:
+			result <- ErrTimeout
+		}
+	}()
+	return result
+}
+
+// requestStatus is request for commands answered with a bare status code.
+func (g *Blynk) requestStatus(ctx context.Context, msg BlynkMessage) error {
+	cmd := msg.Head.Command
+	resp, err := g.request(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if resp.Status != BLYNK_SUCCESS {
+		g.disableCapability(cmd, resp.Status)
+		return statusError(cmd, resp.Status)
+	}
+	return nil
+}
+
+// requestWriteStatus sends a hardware write. The server answers a write
+// only when it fails, so without a ctx deadline the call returns once msg is
+// sent, with a deadline no answer before it passes counts as success.
+func (g *Blynk) requestWriteStatus(ctx context.Context, msg BlynkMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		if _, err := g.sendMessage(msg); err != nil {
+			return fmt.Errorf("send %s failed, %w", CommandName(msg.Head.Command), err)
+		}
+		return nil
+	}
+
+	err := g.requestStatus(ctx, msg)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) {
+		return nil
+	}
+	return err
+}
+
+// receiveStatus reads the response for id without Processing, frames in
+// between are queued for the processor.
+func (g *Blynk) receiveStatus(ctx context.Context, id uint16) (*BlynkRespose, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(g.timeoutMAX)
+	}
+
+	resp, err := g.awaitResponse(deadline, id, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ResponseKey selects the waiters an incoming message resolves: responses
+// match by message id, pin values sent by the server by type ("vw", "dw",
+// "aw") and pin, since they carry no id of the request.
+type ResponseKey struct {
+	ID   uint16
+	Kind string
+	Pin  int
+}
+
+// ResponseMatcher returns the key resp resolves, false for messages no
+// waiter can be waiting for.
+type ResponseMatcher func(resp *BlynkRespose) (ResponseKey, bool)
+
+func idKey(id uint16) ResponseKey {
+	return ResponseKey{ID: id}
+}
+
+func pinKey(kind string, pin int) ResponseKey {
+	return ResponseKey{Kind: kind, Pin: pin}
+}
+
+// MatchResponse is the default ResponseMatcher, it keys responses by message
+// id and "vw", "dw" and "aw" values by type and pin.
+func MatchResponse(resp *BlynkRespose) (ResponseKey, bool) {
+	switch resp.Command {
+	case BLYNK_CMD_RESPONSE:
+		return idKey(resp.MessageId), true
+	case BLYNK_CMD_HARDWARE:
+		if len(resp.Values) < 2 {
+			return ResponseKey{}, false
+		}
+		switch resp.Values[0] {
+		case "vw", "dw", "aw":
+			pin, err := strconv.Atoi(resp.Values[1])
+			if err != nil {
+				return ResponseKey{}, false
+			}
+			return pinKey(resp.Values[0], pin), true
+		}
+	}
+	return ResponseKey{}, false
+}
+
+// SetResponseMatcher replaces the matcher resolving waiting requests and sync
+// reads, nil restores MatchResponse. The keys it returns must be those the
+// waiters register: ResponseKey{ID: id} for responses and
+// ResponseKey{Kind: kind, Pin: pin} for pin values.
+func (g *Blynk) SetResponseMatcher(m ResponseMatcher) {
+	if m == nil {
+		m = MatchResponse
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.matcher = m
+}
+
+func (g *Blynk) addWaiter(key ResponseKey) chan *BlynkRespose {
+	ch := make(chan *BlynkRespose, 1)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.waiters[key] = append(g.waiters[key], ch)
+	return ch
+}
+
+func (g *Blynk) removeWaiter(key ResponseKey, ch chan *BlynkRespose) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	waiters := g.waiters[key]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(g.waiters, key)
+	} else {
+		g.waiters[key] = waiters
+	}
+}
+
+// resolveWaiters hands resp to every waiter of its key.
+func (g *Blynk) resolveWaiters(resp *BlynkRespose) bool {
+	g.lock.Lock()
+	matcher := g.matcher
+	g.lock.Unlock()
+	key, ok := matcher(resp)
+	if !ok {
+		return false
+	}
+	g.lock.Lock()
+	waiters := g.waiters[key]
+	delete(g.waiters, key)
+	g.lock.Unlock()
+
+	for _, ch := range waiters {
+		select {
+		case ch <- resp:
+		default:
+		}
+	}
+	return len(waiters) > 0
+}
+
+// Ping sends a ping and returns the round trip time.
+func (g *Blynk) Ping(ctx context.Context) (time.Duration, error) {
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_PING
+	msg.Head.MessageId = g.getMessageID()
+
+	start := time.Now()
+	if err := g.requestStatus(ctx, msg); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
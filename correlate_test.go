@@ -0,0 +1,117 @@
+package blynk
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReceiveStatusSkipsUnrelatedFrames(t *testing.T) {
+	g, server := newPipeClient(t)
+
+	go func() {
+		req := readServer(t, server)
+		server.Write(frame(BLYNK_CMD_HARDWARE, 0, "vw", "3", "1"))
+		server.Write(responseFrame(req.MessageId+1, BLYNK_ILLEGAL_COMMAND))
+		server.Write(responseFrame(req.MessageId, BLYNK_SUCCESS))
+	}()
+
+	if err := g.Notify("hello"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(g.recvMsg) != 2 {
+		t.Errorf("queued frames = %d, want the hardware frame and the foreign response", len(g.recvMsg))
+	}
+}
+
+func TestRequestRemovesWaiter(t *testing.T) {
+	g, server := newPipeClient(t)
+	g.processingUsing = true
+
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_NOTIFY
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("done")
+
+	go func() {
+		req := readServer(t, server)
+		g.resolveWaiters(&BlynkRespose{Command: BLYNK_CMD_RESPONSE, MessageId: req.MessageId, Status: BLYNK_SUCCESS})
+	}()
+	if err := g.requestStatus(context.Background(), msg); err != nil {
+		t.Fatalf("requestStatus: %v", err)
+	}
+	if n := len(g.waiters); n != 0 {
+		t.Errorf("waiters after completion = %d, want 0", n)
+	}
+
+	msg = BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_NOTIFY
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("lost")
+
+	go func() { readServer(t, server) }()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := g.requestStatus(ctx, msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("requestStatus = %v, want deadline exceeded", err)
+	}
+	if n := len(g.waiters); n != 0 {
+		t.Errorf("waiters after timeout = %d, want 0", n)
+	}
+}
+
+func TestVirtualReadSyncOutOfOrder(t *testing.T) {
+	g, server := newPipeClient(t)
+	g.processingUsing = true
+
+	go func() {
+		readServer(t, server)
+		readServer(t, server)
+		// answered in the reverse order of the requests
+		g.resolveWaiters(&BlynkRespose{Command: BLYNK_CMD_HARDWARE, Values: []string{"vw", "7", "seven"}})
+		g.resolveWaiters(&BlynkRespose{Command: BLYNK_CMD_HARDWARE, Values: []string{"vw", "5", "five"}})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	results := make(chan string, 2)
+	for _, pin := range []int{5, 7} {
+		pin := pin
+		go func() {
+			values, err := g.VirtualReadSync(ctx, pin)
+			if err != nil {
+				t.Errorf("VirtualReadSync(%d): %v", pin, err)
+				results <- ""
+				return
+			}
+			results <- strconv.Itoa(pin) + "=" + values[0]
+		}()
+	}
+
+	got := map[string]bool{<-results: true, <-results: true}
+	if !got["5=five"] || !got["7=seven"] {
+		t.Errorf("results = %v, want 5=five and 7=seven", got)
+	}
+}
+
+func TestSetResponseMatcher(t *testing.T) {
+	g := NewBlynk("token")
+	// the server of this test sends pin values as "vr"
+	g.SetResponseMatcher(func(resp *BlynkRespose) (ResponseKey, bool) {
+		if resp.Command == BLYNK_CMD_HARDWARE && len(resp.Values) > 1 && resp.Values[0] == "vr" {
+			pin, _ := strconv.Atoi(resp.Values[1])
+			return ResponseKey{Kind: "vw", Pin: pin}, true
+		}
+		return MatchResponse(resp)
+	})
+
+	ch := g.addWaiter(pinKey("vw", 3))
+	if !g.resolveWaiters(&BlynkRespose{Command: BLYNK_CMD_HARDWARE, Values: []string{"vr", "3", "1"}}) {
+		t.Fatal("custom matcher not used")
+	}
+	if resp := <-ch; resp.Values[2] != "1" {
+		t.Errorf("value = %q, want 1", resp.Values[2])
+	}
+}
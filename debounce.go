@@ -0,0 +1,83 @@
+package blynk
+
+import (
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+type debounce struct {
+	period  time.Duration
+	active  bool
+	pending bool
+	value   string
+}
+
+func (g *Blynk) SetPinDebounce(pin int, d time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if d <= 0 {
+		delete(g.debounces, pin)
+		return
+	}
+	if db, ok := g.debounces[pin]; ok {
+		db.period = d
+		return
+	}
+	g.debounces[pin] = &debounce{period: d}
+}
+
+// debounceWrite reports whether the write was absorbed by the pin's debounce
+// window. The first write opens the window and is sent right away, later ones
+// only replace the pending value which is sent when the window closes.
+func (g *Blynk) debounceWrite(pin int, value string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	db, ok := g.debounces[pin]
+	if !ok {
+		return false
+	}
+	if db.active {
+		db.value = value
+		db.pending = true
+		return true
+	}
+	db.active = true
+	time.AfterFunc(db.period, func() { g.flushDebounce(pin, db) })
+	return false
+}
+
+func (g *Blynk) flushDebounce(pin int, db *debounce) {
+	g.lock.Lock()
+	value, pending := db.value, db.pending
+	db.active, db.pending, db.value = false, false, ""
+	g.lock.Unlock()
+
+	if !pending {
+		return
+	}
+	// not VirtualWrite, the trailing value must go out while StopGracefully
+	// drains
+	if g.bufferWrite(pin, value) {
+		return
+	}
+	if err := g.virtualWrite(pin, value); err != nil {
+		slog.Printf("[ERROR] debounce: write to pin %d failed, %s", pin, err.Error())
+	}
+}
+
+// flushDebounces sends the pending values of all debounce windows now.
+func (g *Blynk) flushDebounces() {
+	g.lock.Lock()
+	dbs := make(map[int]*debounce, len(g.debounces))
+	for pin, db := range g.debounces {
+		if db.pending {
+			dbs[pin] = db
+		}
+	}
+	g.lock.Unlock()
+
+	for pin, db := range dbs {
+		g.flushDebounce(pin, db)
+	}
+}
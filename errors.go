@@ -0,0 +1,49 @@
+package blynk
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotificationsDisabled = errors.New("notifications are disabled for the device")
+	ErrDeviceOffline         = errors.New("device is offline")
+	ErrProtocolMismatch      = errors.New("protocol version mismatch")
+	ErrInvalidToken          = errors.New("invalid auth token")
+	ErrReadOnly              = errors.New("client is read-only")
+	ErrTimeout               = errors.New("operation timed out")
+	ErrPoolClosed            = errors.New("pool is closed")
+	ErrNotConnected          = errors.New("not connected")
+	ErrClosed                = errors.New("client is stopped")
+	ErrFrameTooLarge         = errors.New("incoming frame too large")
+	ErrThrottled             = errors.New("rate limit exceeded")
+	ErrInvalidEmail          = errors.New("invalid email address")
+	ErrUnsupported           = errors.New("not supported by the server")
+)
+
+// BlynkError is returned when the server answers a command with a status
+// other than BLYNK_SUCCESS.
+type BlynkError struct {
+	Command BlynkCommand
+	Code    uint16
+}
+
+func (e *BlynkError) Error() string {
+	return fmt.Sprintf("%s failed, cause: %s (%d)", CommandName(e.Command), GetBlynkStatus(e.Code), e.Code)
+}
+
+func (e *BlynkError) Unwrap() error {
+	switch e.Code {
+	case BLYNK_NTF_NOT_AUTHORIZED:
+		return ErrNotificationsDisabled
+	case BLYNK_DEVICE_NOT_IN_NETWORK, BLYNK_DEVICE_WENT_OFFLINE:
+		return ErrDeviceOffline
+	case BLYNK_INVALID_TOKEN:
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func statusError(cmd BlynkCommand, status uint16) error {
+	return &BlynkError{Command: cmd, Code: status}
+}
@@ -0,0 +1,63 @@
+package blynk
+
+import "fmt"
+
+// EventType is the kind of a connection lifecycle event.
+type EventType int
+
+const (
+	EventConnected EventType = iota
+	EventAuthFailed
+	EventDisconnected
+	EventReconnecting
+	EventPingTimeout
+	EventServerKick
+)
+
+var eventNames = map[EventType]string{
+	EventConnected:    "connected",
+	EventAuthFailed:   "auth failed",
+	EventDisconnected: "disconnected",
+	EventReconnecting: "reconnecting",
+	EventPingTimeout:  "ping timeout",
+	EventServerKick:   "server kick",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("event(%d)", int(t))
+}
+
+// Event is delivered to the SetOnEvent callback, Err holds the cause when
+// there is one.
+type Event struct {
+	Type EventType
+	Err  error
+}
+
+func (e Event) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Type, e.Err.Error())
+	}
+	return e.Type.String()
+}
+
+// SetOnEvent registers a callback receiving all lifecycle events as one
+// stream, the specific callbacks like SetOnConnect keep working alongside.
+// fn is called synchronously and must not block.
+func (g *Blynk) SetOnEvent(fn func(Event)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onEvent = fn
+}
+
+func (g *Blynk) emitEvent(t EventType, err error) {
+	g.lock.Lock()
+	fn := g.onEvent
+	g.lock.Unlock()
+	if fn != nil {
+		fn(Event{Type: t, Err: err})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+
+	blynk "github.com/OloloevReal/go-blynk"
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// Connects to the server by IP address while the certificate is still
+// verified against the host name.
+func main() {
+	slog.Printf("Blynk starting, version %s", blynk.Version)
+	defer slog.Println("Go Blynk finished")
+
+	auth := flag.String("auth", "", "set -auth=blynk_token")
+	ip := flag.String("ip", "", "set -ip=server_ip")
+	name := flag.String("name", "blynk-cloud.com", "set -name=certificate_host_name")
+
+	flag.Parse()
+
+	app := blynk.NewBlynk(*auth)
+	app.SetServer(*ip, 443, true)
+	app.SetServerName(*name)
+
+	if err := app.Connect(); err != nil {
+		slog.Fatalln(err)
+	}
+	defer app.Disconnect()
+
+	if err := app.VirtualWrite(9, "7.654"); err != nil {
+		slog.Printf("[ERROR] Send command failed")
+	}
+}
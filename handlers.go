@@ -0,0 +1,57 @@
+package blynk
+
+const handlerQueueSize = 16
+
+// SetHandlerConcurrency runs reader and writer handlers on n workers so a
+// slow handler does not stall other pins. A pin is always served by the same
+// worker, keeping its updates in order. Must be called before Processing,
+// n <= 1 runs handlers inline in the processor.
+func (g *Blynk) SetHandlerConcurrency(n int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.handlerWorkers = n
+}
+
+func (g *Blynk) startHandlerWorkers() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.handlerWorkers <= 1 || g.handlerQueues != nil {
+		return
+	}
+	g.handlerQueues = make([]chan func(), g.handlerWorkers)
+	for i := range g.handlerQueues {
+		q := make(chan func(), handlerQueueSize)
+		g.handlerQueues[i] = q
+		go g.handlerWorker(q)
+	}
+}
+
+func (g *Blynk) handlerWorker(q chan func()) {
+	for {
+		select {
+		case <-g.cancel:
+			return
+		case fn := <-q:
+			fn()
+		}
+	}
+}
+
+// dispatchHandler runs fn on the worker owning pin, or inline when no
+// workers are started.
+func (g *Blynk) dispatchHandler(pin int, fn func()) {
+	g.lock.Lock()
+	queues := g.handlerQueues
+	g.lock.Unlock()
+	if len(queues) == 0 {
+		fn()
+		return
+	}
+	if pin < 0 {
+		pin = -pin
+	}
+	select {
+	case queues[pin%len(queues)] <- fn:
+	case <-g.cancel:
+	}
+}
@@ -0,0 +1,116 @@
+package blynk
+
+import (
+	"fmt"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+const maxMissedPings = 3
+
+// Smoothing factors of the latency estimate, same as the TCP RTT estimator
+// (RFC 6298).
+const (
+	latencyAlpha = 0.125
+	latencyBeta  = 0.25
+)
+
+type linkHealth struct {
+	pingID     uint16
+	pingSentAt time.Time
+	waiting    bool
+	missed     int
+	lastPong   time.Time
+	unhealthy  bool
+	latency    time.Duration
+	jitter     time.Duration
+}
+
+func (g *Blynk) LinkHealthy() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return !g.health.unhealthy
+}
+
+func (g *Blynk) LastPongTime() time.Time {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.health.lastPong
+}
+
+// pingSent is called by keepAlive for every ping, a ping which is still
+// unanswered when the next one goes out counts as missed.
+func (g *Blynk) pingSent(id uint16) {
+	g.lock.Lock()
+	if g.health.waiting {
+		g.health.missed++
+	}
+	g.health.pingID = id
+	g.health.pingSentAt = time.Now()
+	g.health.waiting = true
+	failed := g.health.missed >= maxMissedPings && !g.health.unhealthy
+	if failed {
+		g.health.unhealthy = true
+	}
+	g.lock.Unlock()
+
+	if failed {
+		slog.Printf("[ERROR] Keep-Alive: %d pings unanswered, link is unhealthy", maxMissedPings)
+		g.emitEvent(EventPingTimeout, fmt.Errorf("%d pings unanswered", maxMissedPings))
+		if g.autoReconnect && g.conn != nil {
+			g.conn.Close()
+		}
+	}
+}
+
+// AverageLatency returns the exponentially weighted average of the ping
+// round trip times, zero until the first pong.
+func (g *Blynk) AverageLatency() time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.health.latency
+}
+
+// LatencyJitter returns the smoothed deviation of the ping round trip times
+// from AverageLatency.
+func (g *Blynk) LatencyJitter() time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.health.jitter
+}
+
+// updateLatency folds rtt into the averages, must be called with g.lock held.
+func (h *linkHealth) updateLatency(rtt time.Duration) {
+	if h.latency == 0 {
+		h.latency = rtt
+		h.jitter = rtt / 2
+		return
+	}
+	diff := h.latency - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	h.jitter = time.Duration((1-latencyBeta)*float64(h.jitter) + latencyBeta*float64(diff))
+	h.latency = time.Duration((1-latencyAlpha)*float64(h.latency) + latencyAlpha*float64(rtt))
+}
+
+func (g *Blynk) resetHealth() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.health = linkHealth{}
+}
+
+func (g *Blynk) handlePong(id uint16) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.health.waiting || g.health.pingID != id {
+		return false
+	}
+	g.health.waiting = false
+	g.health.missed = 0
+	g.health.lastPong = time.Now()
+	g.health.unhealthy = false
+	g.health.updateLatency(g.health.lastPong.Sub(g.health.pingSentAt))
+	return true
+}
@@ -0,0 +1,128 @@
+package blynk
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+func (g *Blynk) OnHeartbeatNegotiated(fn func(d time.Duration)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onHeartbeat = fn
+}
+
+func (g *Blynk) getHeartbeat() time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.heartbeat
+}
+
+// SetHeartbeat changes the ping interval, a running session should follow
+// it with RefreshInternal so the server uses the same value.
+func (g *Blynk) SetHeartbeat(d time.Duration) error {
+	if d < time.Second {
+		return fmt.Errorf("SetHeartbeat: interval %s is less than 1s", d)
+	}
+	g.setHeartbeat(d)
+	g.lock.Lock()
+	g.internalDirty = true
+	g.lock.Unlock()
+	return nil
+}
+
+// setHeartbeat changes the heartbeat and makes a running keepAlive pick it up.
+func (g *Blynk) setHeartbeat(d time.Duration) {
+	g.lock.Lock()
+	g.heartbeat = d
+	g.lock.Unlock()
+
+	select {
+	case g.heartbeatReset <- true:
+	default:
+	}
+}
+
+// applyServerHeartbeat looks for the "h-beat" field of an internal message
+// and adopts the server value when it differs from ours.
+func (g *Blynk) applyServerHeartbeat(fields []string) {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] != "h-beat" {
+			continue
+		}
+		sec, err := strconv.Atoi(fields[i+1])
+		if err != nil || sec <= 0 {
+			slog.Printf("[ERROR] heartbeat: bad server value %q", fields[i+1])
+			return
+		}
+		d := time.Duration(sec) * time.Second
+		if d == g.getHeartbeat() {
+			return
+		}
+		slog.Printf("[INFO] heartbeat: server enforces %s", d)
+		g.setHeartbeat(d)
+
+		g.lock.Lock()
+		fn := g.onHeartbeat
+		g.lock.Unlock()
+		if fn != nil {
+			fn(d)
+		}
+		return
+	}
+}
+
+// SetHeartbeatHook sets a function called on every heartbeat, its value is
+// written to the returned virtual pin together with the ping.
+func (g *Blynk) SetHeartbeatHook(fn func() (pin int, value string)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.heartbeatHook = fn
+}
+
+func (g *Blynk) sendHeartbeatHook() {
+	g.lock.Lock()
+	fn := g.heartbeatHook
+	g.lock.Unlock()
+	if fn == nil {
+		return
+	}
+
+	pin, value := fn()
+	if err := g.VirtualWrite(pin, value); err != nil {
+		slog.Printf("[ERROR] heartbeat hook: write to pin %d failed, %s", pin, err.Error())
+	}
+}
+
+// PauseKeepAlive suspends the pings without closing the connection. The
+// server drops a silent connection after about twice its heartbeat, so the
+// pause ends by itself after two heartbeat intervals.
+func (g *Blynk) PauseKeepAlive() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.pausedAt.IsZero() {
+		g.pausedAt = time.Now()
+	}
+}
+
+func (g *Blynk) ResumeKeepAlive() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.pausedAt = time.Time{}
+}
+
+func (g *Blynk) keepAlivePaused() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.pausedAt.IsZero() {
+		return false
+	}
+	if time.Since(g.pausedAt) < 2*g.heartbeat {
+		return true
+	}
+	slog.Printf("[INFO] Keep-Alive: paused for %s, resuming", time.Since(g.pausedAt).Round(time.Second))
+	g.pausedAt = time.Time{}
+	return false
+}
@@ -0,0 +1,105 @@
+package blynk
+
+import (
+	"strings"
+	"time"
+)
+
+const defaultHistorySize = 32
+
+// MessageLog is one entry of the session history. Status is set for
+// responses, Values for every other command.
+type MessageLog struct {
+	Time      time.Time
+	Outgoing  bool
+	Command   BlynkCommand
+	MessageId uint16
+	Status    uint16
+	Values    []string
+	body      string
+}
+
+// SetHistorySize sets how many of the last sent and received messages
+// RecentMessages keeps, 0 disables the history.
+func (g *Blynk) SetHistorySize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	recent := g.recentLocked()
+	if len(recent) > n {
+		recent = recent[len(recent)-n:]
+	}
+	g.history = make([]MessageLog, n)
+	copy(g.history, recent)
+	g.historySize = n
+	g.historyNext = 0
+	g.historyFull = false
+	if n > 0 {
+		g.historyNext = len(recent) % n
+		g.historyFull = len(recent) == n
+	}
+}
+
+// RecentMessages returns the history oldest first.
+func (g *Blynk) RecentMessages() []MessageLog {
+	g.lock.Lock()
+	recent := g.recentLocked()
+	g.lock.Unlock()
+	for i := range recent {
+		if recent[i].body != "" {
+			recent[i].Values = strings.Split(recent[i].body, "\x00")
+			recent[i].body = ""
+		}
+	}
+	return recent
+}
+
+func (g *Blynk) recentLocked() []MessageLog {
+	if !g.historyFull {
+		return append([]MessageLog(nil), g.history[:g.historyNext]...)
+	}
+	recent := make([]MessageLog, 0, len(g.history))
+	recent = append(recent, g.history[g.historyNext:]...)
+	return append(recent, g.history[:g.historyNext]...)
+}
+
+func (g *Blynk) recordHistory(entry MessageLog) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.historySize == 0 {
+		return
+	}
+	if g.history == nil {
+		g.history = make([]MessageLog, g.historySize)
+	}
+	entry.Time = time.Now()
+	g.history[g.historyNext] = entry
+	g.historyNext = (g.historyNext + 1) % g.historySize
+	if g.historyNext == 0 {
+		g.historyFull = true
+	}
+}
+
+func (g *Blynk) recordSent(msg *BlynkMessage) {
+	entry := MessageLog{Outgoing: true, Command: msg.Head.Command, MessageId: msg.Head.MessageId}
+	switch msg.Head.Command {
+	case BLYNK_CMD_RESPONSE:
+		entry.Status = msg.Head.Length
+	case BLYNK_CMD_HW_LOGIN:
+		entry.Values = []string{"***"}
+	default:
+		// split lazily in RecentMessages to keep the send path cheap
+		entry.body = msg.Body.String()
+	}
+	g.recordHistory(entry)
+}
+
+func (g *Blynk) recordReceived(resp *BlynkRespose) {
+	entry := MessageLog{Command: resp.Command, MessageId: resp.MessageId, Values: resp.Values}
+	if resp.Command == BLYNK_CMD_RESPONSE {
+		entry.Status = resp.Status
+	}
+	g.recordHistory(entry)
+}
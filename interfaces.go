@@ -0,0 +1,27 @@
+package blynk
+
+import "io"
+
+type VirtualWriter interface {
+	VirtualWrite(pin int, value string) error
+}
+
+type DigitalWriter interface {
+	DigitalWrite(pin int, value bool) error
+}
+
+type Notifier interface {
+	Notify(msg string) error
+}
+
+type Connector interface {
+	Connect() error
+	io.Closer
+}
+
+var (
+	_ VirtualWriter = (*Blynk)(nil)
+	_ DigitalWriter = (*Blynk)(nil)
+	_ Notifier      = (*Blynk)(nil)
+	_ Connector     = (*Blynk)(nil)
+)
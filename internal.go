@@ -0,0 +1,139 @@
+package blynk
+
+import (
+	"strconv"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// SetOnInternal registers a callback receiving the fields of every internal
+// message sent by the server, before the built-in handlers run.
+func (g *Blynk) SetOnInternal(fn func(fields []string)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onInternal = fn
+}
+
+func (g *Blynk) SetOnRTC(fn func(t time.Time)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onRTC = fn
+}
+
+func (g *Blynk) SetOnAppConnection(fn func(connected bool)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onAppConnection = fn
+}
+
+// SetOnOTA registers a callback receiving the firmware URL of an "ota"
+// internal message, downloading and applying the update is up to fn.
+func (g *Blynk) SetOnOTA(fn func(url string)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onOTA = fn
+}
+
+func (g *Blynk) handleInternal(fields []string) {
+	g.lock.Lock()
+	onInternal, onRTC, onApp, onOTA := g.onInternal, g.onRTC, g.onAppConnection, g.onOTA
+	g.lock.Unlock()
+
+	if onInternal != nil {
+		onInternal(fields)
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	switch fields[0] {
+	case "rtc":
+		if len(fields) < 2 {
+			return
+		}
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			slog.Printf("[ERROR] internal: bad rtc value %q", fields[1])
+			return
+		}
+		g.setServerTime(time.Unix(sec, 0))
+		if onRTC != nil {
+			onRTC(time.Unix(sec, 0))
+		}
+	case "acon", "adis":
+		if onApp != nil {
+			onApp(fields[0] == "acon")
+		}
+	case "ota":
+		if len(fields) < 2 || fields[1] == "" {
+			slog.Printf("[ERROR] internal: ota without firmware url")
+			return
+		}
+		if onOTA != nil {
+			onOTA(fields[1])
+		} else {
+			slog.Printf("[INFO] internal: ota %s ignored, no handler", fields[1])
+		}
+	case "h-beat":
+		g.applyServerHeartbeat(fields)
+	case "dash":
+		slog.Printf("[DEBUG] internal: dashboard %v", fields[1:])
+	default:
+		slog.Printf("[DEBUG] internal: unhandled %v", fields)
+	}
+}
+
+// ReportRSSI sends the signal strength shown in the device info of the app,
+// as the internal field "rssi" with the value in dBm.
+func (g *Blynk) ReportRSSI(dbm int) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_INTERNAL
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("rssi")
+	msg.Body.AddInt(dbm)
+	msg.Head.Length = msg.Body.Len()
+
+	if _, err := g.sendMessage(msg); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RequestServerTime asks the server for its clock, the answer updates
+// ServerTimeOffset and is passed to the SetOnRTC callback.
+func (g *Blynk) RequestServerTime() error {
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_INTERNAL
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("rtc")
+	msg.Body.AddString("sync")
+	msg.Head.Length = msg.Body.Len()
+
+	_, err := g.sendMessage(msg)
+	return err
+}
+
+// ServerTimeOffset returns how far the server clock is ahead of the local
+// one, as of the last rtc message. Zero until the server sent its time.
+func (g *Blynk) ServerTimeOffset() time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.serverOffset
+}
+
+// ServerNow returns the local time corrected by ServerTimeOffset.
+func (g *Blynk) ServerNow() time.Time {
+	return time.Now().Add(g.ServerTimeOffset())
+}
+
+// setServerTime records the offset to the server clock, the time took about
+// half a round trip to arrive.
+func (g *Blynk) setServerTime(t time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.serverOffset = t.Add(g.health.latency / 2).Sub(time.Now())
+}
@@ -0,0 +1,31 @@
+package blynk
+
+import (
+	"time"
+)
+
+// SetMaxConnLifetime makes Processing replace the connection once it is older
+// than d. The rotation is checked on every heartbeat and waits until no
+// request, sync read or reliable write is in flight.
+func (g *Blynk) SetMaxConnLifetime(d time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.maxLifetime = d
+}
+
+func (g *Blynk) markConnected() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.connectedAt = time.Now()
+}
+
+func (g *Blynk) checkLifetime() {
+	g.lock.Lock()
+	expired := g.maxLifetime > 0 && !g.connectedAt.IsZero() && time.Since(g.connectedAt) > g.maxLifetime
+	quiet := len(g.waiters) == 0 && len(g.unacked) == 0
+	g.lock.Unlock()
+
+	if expired && quiet {
+		g.requestReconnect("connection lifetime exceeded")
+	}
+}
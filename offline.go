@@ -0,0 +1,121 @@
+package blynk
+
+import (
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+type offlineMsg struct {
+	cmd BlynkCommand
+	buf []byte
+}
+
+// SetOfflineBuffer keeps up to maxMessages hardware writes made while the
+// client is not connected and sends them in order after the next successful
+// connect. The oldest message is dropped when the buffer is full.
+func (g *Blynk) SetOfflineBuffer(maxMessages int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.offlineMax = maxMessages
+	if len(g.offline) > maxMessages {
+		g.offline = g.offline[len(g.offline)-maxMessages:]
+	}
+}
+
+func (g *Blynk) SetOnConnect(fn func()) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onConnect = fn
+}
+
+// SetOnline(false) takes the device offline on purpose: the connection is
+// closed cleanly, so the server shows the device offline right away instead
+// of after a heartbeat timeout, and no reconnect is attempted. The protocol
+// has no status command for this. SetOnline(true) connects again.
+func (g *Blynk) SetOnline(online bool) error {
+	g.lock.Lock()
+	if online == (g.onlineWait == nil) {
+		g.lock.Unlock()
+		return nil
+	}
+	if !online {
+		g.onlineWait = make(chan struct{})
+		conn := g.conn
+		g.lock.Unlock()
+		slog.Printf("[INFO] SetOnline: going offline")
+		g.setConnected(false)
+		if conn != nil {
+			return conn.Close()
+		}
+		return nil
+	}
+	ch := g.onlineWait
+	g.onlineWait = nil
+	g.lock.Unlock()
+
+	slog.Printf("[INFO] SetOnline: going online")
+	if g.processingUsing {
+		close(ch)
+		return nil
+	}
+	return g.connect()
+}
+
+// offlineWait returns the channel closed by SetOnline(true), nil while the
+// device is not held offline.
+func (g *Blynk) offlineWait() chan struct{} {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.onlineWait
+}
+
+func (g *Blynk) setConnected(state bool) {
+	if !state {
+		g.endConnContext()
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.connected = state
+}
+
+func (g *Blynk) isConnected() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.conn != nil && g.connected
+}
+
+func (g *Blynk) bufferOffline(msg *BlynkMessage) bool {
+	if msg.Head.Command != BLYNK_CMD_HARDWARE {
+		return false
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.offlineMax <= 0 {
+		return false
+	}
+	if len(g.offline) >= g.offlineMax {
+		slog.Printf("[ERROR] offline buffer is full, oldest message dropped")
+		g.offline = g.offline[1:]
+	}
+	g.offline = append(g.offline, offlineMsg{cmd: msg.Head.Command, buf: msg.GetBytes()})
+	return true
+}
+
+func (g *Blynk) flushOffline() {
+	g.lock.Lock()
+	pending := g.offline
+	g.offline = nil
+	g.lock.Unlock()
+
+	for i, m := range pending {
+		if err := g.writeWithRetry(m.cmd, m.buf); err != nil {
+			slog.Printf("[ERROR] offline buffer: flush failed, %s", err.Error())
+			g.lock.Lock()
+			g.offline = append(pending[i:], g.offline...)
+			g.lock.Unlock()
+			return
+		}
+	}
+	if len(pending) > 0 {
+		slog.Printf("[DEBUG] offline buffer: flushed %d messages", len(pending))
+	}
+}
@@ -0,0 +1,112 @@
+package blynk
+
+import (
+	"sync"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// Pool keeps up to size authenticated connections for the same token, each
+// Do borrows one of them for a single operation.
+type Pool struct {
+	APIkey    string
+	idle      chan *Blynk
+	configure func(*Blynk)
+	timeout   time.Duration
+	lock      sync.Mutex
+	closed    bool
+}
+
+func NewPool(APIkey string, size int) *Pool {
+	return &Pool{
+		APIkey:  APIkey,
+		idle:    make(chan *Blynk, size),
+		timeout: time.Second * 5,
+	}
+}
+
+// SetConfigure sets a function applied to every new connection before
+// Connect, e.g. to call SetServer.
+func (p *Pool) SetConfigure(fn func(*Blynk)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.configure = fn
+}
+
+func (p *Pool) Do(fn func(*Blynk) error) error {
+	g, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = fn(g)
+	p.put(g)
+	return err
+}
+
+func (p *Pool) get() (*Blynk, error) {
+	for {
+		select {
+		case g, ok := <-p.idle:
+			if !ok {
+				return nil, ErrPoolClosed
+			}
+			if err := g.ping(p.timeout); err != nil {
+				slog.Printf("[DEBUG] Pool: discarding dead connection, %s", err.Error())
+				g.Close()
+				continue
+			}
+			return g, nil
+		default:
+			return p.dial()
+		}
+	}
+}
+
+func (p *Pool) dial() (*Blynk, error) {
+	p.lock.Lock()
+	closed, configure := p.closed, p.configure
+	p.lock.Unlock()
+	if closed {
+		return nil, ErrPoolClosed
+	}
+
+	g := NewBlynk(p.APIkey)
+	g.DisableLogo(true)
+	if configure != nil {
+		configure(g)
+	}
+	if err := g.Connect(); err != nil {
+		g.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+func (p *Pool) put(g *Blynk) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		g.Close()
+		return
+	}
+	select {
+	case p.idle <- g:
+	default:
+		g.Close()
+	}
+}
+
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.idle)
+	for g := range p.idle {
+		g.Close()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package blynk
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func (g *Blynk) SetProperty(pin int, property string, values ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	return g.SetPropertyContext(ctx, pin, property, values...)
+}
+
+func (g *Blynk) SetPropertyContext(ctx context.Context, pin int, property string, values ...string) error {
+	return g.setProperty(ctx, strconv.Itoa(pin), property, values...)
+}
+
+// SetDeviceProperty sets a property of the device itself, e.g. its name or
+// status color. The frame is the same as for a pin property but the pin
+// field holds the word "dev": dev\0property\0value...
+func (g *Blynk) SetDeviceProperty(property string, values ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	return g.setProperty(ctx, "dev", property, values...)
+}
+
+// GetProperty would read a widget property back. The hardware protocol only
+// lets devices set properties, so it always returns ErrUnsupported; it is
+// kept for servers adding reads later.
+func (g *Blynk) GetProperty(pin int, property string, timeout time.Duration) (string, error) {
+	return "", fmt.Errorf("get property %q of pin %d: %w", property, pin, ErrUnsupported)
+}
+
+func (g *Blynk) setProperty(ctx context.Context, target string, property string, values ...string) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_PROPERTY
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString(target)
+	msg.Body.AddString(property)
+	for _, v := range values {
+		msg.Body.AddString(v)
+	}
+	msg.Head.Length = msg.Body.Len()
+
+	return g.requestStatus(ctx, msg)
+}
+
+func (g *Blynk) ShowWidget(pin int) error {
+	return g.SetProperty(pin, "isHidden", "false")
+}
+
+func (g *Blynk) HideWidget(pin int) error {
+	return g.SetProperty(pin, "isHidden", "true")
+}
+
+func (g *Blynk) EnableWidget(pin int) error {
+	return g.SetProperty(pin, "isDisabled", "false")
+}
+
+func (g *Blynk) DisableWidget(pin int) error {
+	return g.SetProperty(pin, "isDisabled", "true")
+}
+
+// SetUnit sets the text shown after the value of a display widget, e.g.
+// "°C", through the "suffix" property.
+func (g *Blynk) SetUnit(pin int, suffix string) error {
+	if err := checkLabel(suffix); err != nil {
+		return fmt.Errorf("SetUnit: %w", err)
+	}
+	return g.SetProperty(pin, "suffix", suffix)
+}
+
+// SetPrefix sets the text shown before the value of a display widget.
+func (g *Blynk) SetPrefix(pin int, prefix string) error {
+	if err := checkLabel(prefix); err != nil {
+		return fmt.Errorf("SetPrefix: %w", err)
+	}
+	return g.SetProperty(pin, "prefix", prefix)
+}
+
+// SetSwitchLabels sets the onLabel and offLabel of a button widget.
+func (g *Blynk) SetSwitchLabels(pin int, on, off string) error {
+	if err := checkLabel(on); err != nil {
+		return fmt.Errorf("SetSwitchLabels: %w", err)
+	}
+	if err := checkLabel(off); err != nil {
+		return fmt.Errorf("SetSwitchLabels: %w", err)
+	}
+	if err := g.SetProperty(pin, "onLabel", on); err != nil {
+		return err
+	}
+	return g.SetProperty(pin, "offLabel", off)
+}
+
+// checkLabel rejects text which would break the 0x00 separated body.
+func checkLabel(s string) error {
+	if strings.IndexByte(s, 0x00) >= 0 {
+		return fmt.Errorf("label %q contains a zero byte", s)
+	}
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("label %q is not valid UTF-8", s)
+	}
+	return nil
+}
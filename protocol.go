@@ -39,27 +39,89 @@ const (
 	BLYNK_CMD_NOTIFY        BlynkCommand = 14
 	BLYNK_CMD_HARDWARE_SYNC BlynkCommand = 16
 	BLYNK_CMD_INTERNAL      BlynkCommand = 17
+	BLYNK_CMD_PROPERTY      BlynkCommand = 19
 	BLYNK_CMD_HARDWARE      BlynkCommand = 20
 	BLYNK_CMD_HW_LOGIN      BlynkCommand = 29
 )
 
 const (
-	BLYNK_SUCCESS             uint16 = 200
-	BLYNK_ILLEGAL_COMMAND     uint16 = 2
-	BLYNK_NOT_REGISTERED      uint16 = 3
-	BLYNK_NOT_AUTHENTICATED   uint16 = 5
-	BLYNK_NOT_ALLOWED         uint16 = 6
-	BLYNK_NO_ACTIVE_DASHBOARD uint16 = 8
-	BLYNK_INVALID_TOKEN       uint16 = 9
-	BLYNK_NTF_INVALID_BODY    uint16 = 13
-	BLYNK_NTF_NOT_AUTHORIZED  uint16 = 14
-	BLYNK_NTF_EXCEPTION       uint16 = 15
+	BLYNK_SUCCESS               uint16 = 200
+	BLYNK_QUOTA_LIMIT           uint16 = 1
+	BLYNK_ILLEGAL_COMMAND       uint16 = 2
+	BLYNK_NOT_REGISTERED        uint16 = 3
+	BLYNK_NOT_AUTHENTICATED     uint16 = 5
+	BLYNK_NOT_ALLOWED           uint16 = 6
+	BLYNK_DEVICE_NOT_IN_NETWORK uint16 = 7
+	BLYNK_NO_ACTIVE_DASHBOARD   uint16 = 8
+	BLYNK_INVALID_TOKEN         uint16 = 9
+	BLYNK_NTF_INVALID_BODY      uint16 = 13
+	BLYNK_NTF_NOT_AUTHORIZED    uint16 = 14
+	BLYNK_NTF_EXCEPTION         uint16 = 15
+	BLYNK_DEVICE_WENT_OFFLINE   uint16 = 18
+	BLYNK_NOT_SUPPORTED_VERSION uint16 = 20
 )
 
+// BlynkStatus is the typed form of a response status code.
+type BlynkStatus uint16
+
+const (
+	StatusSuccess             = BlynkStatus(BLYNK_SUCCESS)
+	StatusQuotaLimit          = BlynkStatus(BLYNK_QUOTA_LIMIT)
+	StatusIllegalCommand      = BlynkStatus(BLYNK_ILLEGAL_COMMAND)
+	StatusNotRegistered       = BlynkStatus(BLYNK_NOT_REGISTERED)
+	StatusNotAuthenticated    = BlynkStatus(BLYNK_NOT_AUTHENTICATED)
+	StatusNotAllowed          = BlynkStatus(BLYNK_NOT_ALLOWED)
+	StatusDeviceNotInNetwork  = BlynkStatus(BLYNK_DEVICE_NOT_IN_NETWORK)
+	StatusNoActiveDashboard   = BlynkStatus(BLYNK_NO_ACTIVE_DASHBOARD)
+	StatusInvalidToken        = BlynkStatus(BLYNK_INVALID_TOKEN)
+	StatusNtfInvalidBody      = BlynkStatus(BLYNK_NTF_INVALID_BODY)
+	StatusNtfNotAuthorized    = BlynkStatus(BLYNK_NTF_NOT_AUTHORIZED)
+	StatusNtfException        = BlynkStatus(BLYNK_NTF_EXCEPTION)
+	StatusDeviceWentOffline   = BlynkStatus(BLYNK_DEVICE_WENT_OFFLINE)
+	StatusNotSupportedVersion = BlynkStatus(BLYNK_NOT_SUPPORTED_VERSION)
+)
+
+// Status converts a response status code, unknown codes keep their value
+// and print as UNDEFINED.
+func Status(code uint16) BlynkStatus {
+	return BlynkStatus(code)
+}
+
+func (s BlynkStatus) String() string {
+	return GetBlynkStatus(uint16(s))
+}
+
+var commandNames = map[BlynkCommand]string{
+	BLYNK_CMD_RESPONSE:      "RESPONSE",
+	BLYNK_CMD_LOGIN:         "LOGIN",
+	BLYNK_CMD_PING:          "PING",
+	BLYNK_CMD_TWEET:         "TWEET",
+	BLYNK_CMD_EMAIL:         "EMAIL",
+	BLYNK_CMD_NOTIFY:        "NOTIFY",
+	BLYNK_CMD_HARDWARE_SYNC: "HARDWARE_SYNC",
+	BLYNK_CMD_INTERNAL:      "INTERNAL",
+	BLYNK_CMD_PROPERTY:      "PROPERTY",
+	BLYNK_CMD_HARDWARE:      "HARDWARE",
+	BLYNK_CMD_HW_LOGIN:      "HW_LOGIN",
+}
+
+func CommandName(cmd BlynkCommand) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", byte(cmd))
+}
+
+func (c BlynkCommand) String() string {
+	return CommandName(c)
+}
+
 func GetBlynkStatus(status uint16) string {
 	switch status {
 	case BLYNK_SUCCESS:
 		return "SUCCESS"
+	case BLYNK_QUOTA_LIMIT:
+		return "QUOTA_LIMIT"
 	case BLYNK_ILLEGAL_COMMAND:
 		return "ILLEGAL_COMMAND"
 	case BLYNK_NOT_REGISTERED:
@@ -68,6 +130,8 @@ func GetBlynkStatus(status uint16) string {
 		return "NOT_AUTHENTICATED"
 	case BLYNK_NOT_ALLOWED:
 		return "NOT_ALLOWED"
+	case BLYNK_DEVICE_NOT_IN_NETWORK:
+		return "DEVICE_NOT_IN_NETWORK"
 	case BLYNK_NO_ACTIVE_DASHBOARD:
 		return "NO_ACTIVE_DASHBOARD"
 	case BLYNK_INVALID_TOKEN:
@@ -78,6 +142,10 @@ func GetBlynkStatus(status uint16) string {
 		return "NTF_NOT_AUTHORIZED"
 	case BLYNK_NTF_EXCEPTION:
 		return "NTF_EXCEPTION"
+	case BLYNK_DEVICE_WENT_OFFLINE:
+		return "DEVICE_WENT_OFFLINE"
+	case BLYNK_NOT_SUPPORTED_VERSION:
+		return "NOT_SUPPORTED_VERSION"
 	default:
 		return "UNDEFINED"
 	}
@@ -102,6 +170,54 @@ func (b *BlynkMessage) GetBytes() []byte {
 	return writer.Bytes()
 }
 
+// Encode returns the frame as it is written to the connection, the head
+// length is taken from the body except for responses where it holds the
+// status code.
+func (b *BlynkMessage) Encode() ([]byte, error) {
+	if b == nil {
+		return nil, fmt.Errorf("BlynkMessage is nil")
+	}
+
+	return b.appendTo(make([]byte, 0, 5+int(b.Body.Len()))), nil
+}
+
+// appendTo appends the encoded frame to dst without intermediate buffers,
+// the send path uses it with pooled buffers.
+func (b *BlynkMessage) appendTo(dst []byte) []byte {
+	length := b.Head.Length
+	if b.Head.Command != BLYNK_CMD_RESPONSE {
+		length = b.Body.Len()
+	}
+	dst = append(dst, byte(b.Head.Command), 0, 0, 0, 0)
+	head := dst[len(dst)-4:]
+	binary.BigEndian.PutUint16(head, b.Head.MessageId)
+	binary.BigEndian.PutUint16(head[2:], length)
+	return append(dst, b.Body.String()...)
+}
+
+func (b BlynkMessage) String() string {
+	var parts []string
+	if b.Body.Len() > 0 {
+		parts = strings.Split(b.Body.String(), "\x00")
+	}
+	return fmt.Sprintf("%s id=%d len=%d %s", CommandName(b.Head.Command), b.Head.MessageId, b.Head.Length, quoteParts(parts))
+}
+
+func (r BlynkRespose) String() string {
+	if r.Command == BLYNK_CMD_RESPONSE {
+		return fmt.Sprintf("%s id=%d status=%s(%d)", CommandName(r.Command), r.MessageId, GetBlynkStatus(r.Status), r.Status)
+	}
+	return fmt.Sprintf("%s id=%d len=%d %s", CommandName(r.Command), r.MessageId, r.Status, quoteParts(r.Values))
+}
+
+func quoteParts(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, p := range parts {
+		quoted[i] = strconv.Quote(p)
+	}
+	return "[" + strings.Join(quoted, " ") + "]"
+}
+
 func (b *BlynkHead) getBytes() ([]byte, error) {
 	if b == nil {
 		return nil, fmt.Errorf("BlynkHead is nil")
@@ -110,7 +226,7 @@ func (b *BlynkHead) getBytes() ([]byte, error) {
 	var writer bytes.Buffer
 	err := binary.Write(&writer, binary.BigEndian, b)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
 	return writer.Bytes(), nil
@@ -0,0 +1,60 @@
+package blynk
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeComputesLength(t *testing.T) {
+	for _, length := range []uint16{0, 3, 100} {
+		msg := BlynkMessage{}
+		msg.Head.Command = BLYNK_CMD_HARDWARE
+		msg.Head.MessageId = 7
+		msg.Head.Length = length
+		msg.Body.AddString("vw")
+		msg.Body.AddInt(1)
+		msg.Body.AddString("on")
+
+		buf, err := msg.Encode()
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		body := "vw\x001\x00on"
+		if got := binary.BigEndian.Uint16(buf[3:5]); got != uint16(len(body)) {
+			t.Errorf("Length %d: head length = %d, want %d", length, got, len(body))
+		}
+		if string(buf[5:]) != body {
+			t.Errorf("Length %d: body = %q, want %q", length, buf[5:], body)
+		}
+	}
+}
+
+func TestEncodeResponseKeepsStatus(t *testing.T) {
+	buf := responseFrame(9, BLYNK_ILLEGAL_COMMAND)
+	if len(buf) != 5 {
+		t.Fatalf("frame size = %d, want 5", len(buf))
+	}
+	if got := binary.BigEndian.Uint16(buf[3:5]); got != BLYNK_ILLEGAL_COMMAND {
+		t.Errorf("status = %d, want %d", got, BLYNK_ILLEGAL_COMMAND)
+	}
+}
+
+func TestSendMessageFramesBody(t *testing.T) {
+	g, server := newPipeClient(t)
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_HARDWARE
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("vw")
+	msg.Body.AddInt(4)
+	msg.Body.AddString("42")
+
+	done := make(chan *BlynkRespose, 1)
+	go func() { done <- readServer(t, server) }()
+	if _, err := g.sendMessage(msg); err != nil {
+		t.Fatalf("sendMessage: %v", err)
+	}
+	req := <-done
+	if len(req.Values) != 3 || req.Values[2] != "42" {
+		t.Errorf("server got %v, want [vw 4 42]", req.Values)
+	}
+}
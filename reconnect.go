@@ -0,0 +1,169 @@
+package blynk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+const (
+	reconnectDelayMin = time.Second
+	reconnectDelayMax = time.Minute
+)
+
+func (g *Blynk) SetAutoReconnect(state bool) {
+	g.autoReconnect = state
+}
+
+// SetReconnectPolicy controls whether the reconnect loop keeps retrying after
+// the server rejected the token, by default it gives up on ErrInvalidToken.
+func (g *Blynk) SetReconnectPolicy(retryAuthFailures bool) {
+	g.retryAuthFail = retryAuthFailures
+}
+
+// SetBackoff replaces the delay before each reconnect attempt, counted from
+// 1. A nil fn restores the default, doubling from 1s up to 1m.
+func (g *Blynk) SetBackoff(fn func(attempt int) time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.backoff = fn
+}
+
+func defaultBackoff(attempt int) time.Duration {
+	delay := reconnectDelayMin
+	for i := 1; i < attempt && delay < reconnectDelayMax; i++ {
+		delay *= 2
+	}
+	if delay > reconnectDelayMax {
+		delay = reconnectDelayMax
+	}
+	return delay
+}
+
+func (g *Blynk) reconnectDelay(attempt int) time.Duration {
+	g.lock.Lock()
+	fn := g.backoff
+	g.lock.Unlock()
+	if fn == nil {
+		return defaultBackoff(attempt)
+	}
+	if delay := fn(attempt); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+func (g *Blynk) reconnect() error {
+	for attempt := 1; ; attempt++ {
+		delay := g.reconnectDelay(attempt)
+		select {
+		case <-g.cancel:
+			return errors.New("reconnect: cancelled")
+		case <-time.After(delay):
+		}
+
+		slog.Printf("Reconnect: attempt %d", attempt)
+		g.emitEvent(EventReconnecting, nil)
+		if g.conn != nil {
+			g.conn.Close()
+		}
+		err := g.connect()
+		if err == nil {
+			g.resetHealth()
+			return nil
+		}
+		if errors.Is(err, ErrInvalidToken) && !g.retryAuthFail {
+			return err
+		}
+		slog.Printf("[ERROR] Reconnect: attempt %d failed, %s", attempt, err.Error())
+	}
+}
+
+func (g *Blynk) SetOnReauth(fn func()) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.onReauth = fn
+}
+
+// SetAPIKey replaces the device token, a live connection is re-established
+// so the new token is used right away. A token provider takes precedence
+// over the key.
+func (g *Blynk) SetAPIKey(token string) error {
+	if token == "" {
+		return fmt.Errorf("SetAPIKey: token is empty")
+	}
+	g.lock.Lock()
+	g.APIkey = token
+	g.lock.Unlock()
+
+	if !g.isConnected() {
+		return nil
+	}
+	if g.processingUsing {
+		g.requestReconnect("API key changed")
+		return nil
+	}
+	g.Disconnect()
+	return g.connect()
+}
+
+// requestReconnect makes Processing reconnect even when auto-reconnect is
+// off, closing the connection stops the receiver. Without Processing no one
+// would pick the request up, the connection is replaced right away instead.
+func (g *Blynk) requestReconnect(reason string) bool {
+	if !g.processingUsing {
+		slog.Printf("[INFO] Reconnect: %s", reason)
+		g.Disconnect()
+		if err := g.connect(); err != nil {
+			slog.Printf("[ERROR] Reconnect: %s", err.Error())
+			return false
+		}
+		g.fireReauth()
+		return true
+	}
+
+	g.lock.Lock()
+	if g.reconnectReq {
+		g.lock.Unlock()
+		return false
+	}
+	g.reconnectReq = true
+	conn := g.conn
+	g.lock.Unlock()
+
+	slog.Printf("[INFO] Reconnect: %s", reason)
+	if conn != nil {
+		conn.Close()
+	}
+	return true
+}
+
+func (g *Blynk) takeReconnectRequest() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	req := g.reconnectReq
+	g.reconnectReq = false
+	return req
+}
+
+// handleNotAuthenticated is called when the server reports mid-session that
+// the connection is not authenticated, e.g. after a server restart.
+func (g *Blynk) handleNotAuthenticated() {
+	g.lock.Lock()
+	g.reauthPending = true
+	g.lock.Unlock()
+	g.emitEvent(EventServerKick, errors.New("session is not authenticated"))
+	g.requestReconnect("session is not authenticated")
+}
+
+func (g *Blynk) fireReauth() {
+	g.lock.Lock()
+	pending, fn := g.reauthPending, g.onReauth
+	g.reauthPending = false
+	g.lock.Unlock()
+	if pending && fn != nil {
+		fn()
+	}
+}
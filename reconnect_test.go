@@ -0,0 +1,67 @@
+package blynk
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestNotAuthenticatedWithoutProcessingReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan struct{}, 2)
+	cmds := make(chan BlynkCommand, 10)
+	go func() {
+		for first := true; ; first = false {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			if !first {
+				go func() {
+					defer conn.Close()
+					serveHandshake(t, conn, cmds)
+				}()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				for i := 0; i < 2; i++ {
+					req := readServer(t, conn)
+					conn.Write(responseFrame(req.MessageId, BLYNK_SUCCESS))
+				}
+				// the server restarted and forgot the session
+				req := readServer(t, conn)
+				conn.Write(responseFrame(req.MessageId, BLYNK_NOT_AUTHENTICATED))
+			}()
+		}
+	}()
+
+	g := NewBlynk("token")
+	g.DisableLogo(true)
+	g.SetServer("127.0.0.1", ln.Addr().(*net.TCPAddr).Port, false)
+	reauth := false
+	g.SetOnReauth(func() { reauth = true })
+	if err := g.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer g.Disconnect()
+
+	var blynkErr *BlynkError
+	if err := g.Notify("hello"); !errors.As(err, &blynkErr) || blynkErr.Code != BLYNK_NOT_AUTHENTICATED {
+		t.Errorf("Notify = %v, want not authenticated", err)
+	}
+	if len(accepted) != 2 {
+		t.Errorf("connections = %d, want a second one", len(accepted))
+	}
+	if !g.isConnected() {
+		t.Error("client is not connected after the reconnect")
+	}
+	if !reauth {
+		t.Error("OnReauth not called")
+	}
+}
@@ -0,0 +1,115 @@
+package blynk
+
+import (
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+type unackedMsg struct {
+	buf      []byte
+	tries    int
+	deferred bool
+	timer    *time.Timer
+}
+
+// SetReliableSend makes Notify, Tweet and EMail sent while Processing is
+// running wait for the server response, a message left unanswered for timeout
+// is sent again with the same message id, at most retries times. Zero retries
+// disables it. Hardware writes are not covered, the server answers them only
+// when they fail.
+// A resend is held back once while received frames wait for the processor,
+// since the missing response may be among them.
+//
+// Delivery is at least once. The server does not deduplicate by message id,
+// so a message whose response was lost is delivered again by the resend.
+func (g *Blynk) SetReliableSend(retries int, timeout time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.reliableRetries = retries
+	g.reliableTimeout = timeout
+}
+
+// isAcknowledged reports whether the server answers cmd on success too, only
+// those commands can tell a lost message from a delivered one.
+func isAcknowledged(cmd BlynkCommand) bool {
+	switch cmd {
+	case BLYNK_CMD_NOTIFY, BLYNK_CMD_TWEET, BLYNK_CMD_EMAIL:
+		return true
+	}
+	return false
+}
+
+func (g *Blynk) trackUnacked(msg *BlynkMessage, buf []byte) {
+	if !isAcknowledged(msg.Head.Command) || !g.processingUsing {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.reliableRetries <= 0 {
+		return
+	}
+	id := msg.Head.MessageId
+	// buf belongs to the send buffer pool
+	um := &unackedMsg{buf: append([]byte(nil), buf...)}
+	um.timer = time.AfterFunc(g.reliableTimeout, func() { g.resendUnacked(id, um) })
+	g.unacked[id] = um
+}
+
+func (g *Blynk) resendUnacked(id uint16, um *unackedMsg) {
+	g.lock.Lock()
+	if g.unacked[id] != um {
+		g.lock.Unlock()
+		return
+	}
+	if !um.deferred && len(g.recvMsg) > 0 {
+		um.deferred = true
+		um.timer.Reset(g.reliableTimeout)
+		g.lock.Unlock()
+		return
+	}
+	um.deferred = false
+	if um.tries >= g.reliableRetries {
+		delete(g.unacked, id)
+		g.lock.Unlock()
+		slog.Printf("[ERROR] reliable: message id-%d was not acknowledged after %d resends", id, um.tries)
+		return
+	}
+	um.tries++
+	um.timer.Reset(g.reliableTimeout)
+	g.lock.Unlock()
+
+	slog.Printf("[DEBUG] reliable: resend message id-%d, attempt %d", id, um.tries)
+	if _, err := g.sendBytes(um.buf); err != nil {
+		slog.Printf("[ERROR] reliable: resend message id-%d failed, %s", id, err.Error())
+	}
+}
+
+func (g *Blynk) dropUnacked(id uint16) bool {
+	_, ok := g.takeUnacked(id)
+	return ok
+}
+
+func (g *Blynk) takeUnacked(id uint16) (*unackedMsg, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	um, ok := g.unacked[id]
+	if ok {
+		um.timer.Stop()
+		delete(g.unacked, id)
+	}
+	return um, ok
+}
+
+func (g *Blynk) ackUnacked(id uint16, status uint16) bool {
+	um, ok := g.takeUnacked(id)
+	if ok && um.tries > 0 {
+		// the responses of the other copies find no entry and are ignored
+		slog.Printf("[INFO] reliable: message id-%d acknowledged after %d resends, it may have been delivered more than once", id, um.tries)
+	}
+
+	if ok && status != BLYNK_SUCCESS {
+		slog.Printf("[ERROR] reliable: message id-%d rejected, %s (%d)", id, GetBlynkStatus(status), status)
+	}
+	return ok
+}
@@ -0,0 +1,29 @@
+package blynk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReliableSendTracksAcknowledgedOnly(t *testing.T) {
+	g := NewBlynk("token")
+	g.processingUsing = true
+	g.SetReliableSend(1, time.Hour)
+
+	write := BlynkMessage{}
+	write.Head.Command = BLYNK_CMD_HARDWARE
+	write.Head.MessageId = 1
+	write.Body.AddString("vw")
+	g.trackUnacked(&write, nil)
+	if len(g.unacked) != 0 {
+		t.Error("hardware write tracked, the server does not acknowledge it")
+	}
+
+	notify := BlynkMessage{}
+	notify.Head.Command = BLYNK_CMD_NOTIFY
+	notify.Head.MessageId = 2
+	g.trackUnacked(&notify, nil)
+	if !g.ackUnacked(2, BLYNK_SUCCESS) {
+		t.Error("notify not tracked")
+	}
+}
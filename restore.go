@@ -0,0 +1,52 @@
+package blynk
+
+import (
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// SetSyncOnConnect makes the first connect end with SyncAll too, so writer
+// handlers, subscribers and listeners get the current pin values right away.
+func (g *Blynk) SetSyncOnConnect(state bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.syncOnConnect = state
+}
+
+// SetSyncOnReconnect controls the SyncAll ending every reconnect, it is on by
+// default: values written from the app while the connection was down reach
+// handlers and subscribers only through it.
+func (g *Blynk) SetSyncOnReconnect(state bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.syncOnReconnect = state
+}
+
+// SyncAll asks the server to send the stored values of all pins, they are
+// dispatched like values written from the app.
+func (g *Blynk) SyncAll() error {
+	_, err := g.sendCommand(BLYNK_CMD_HARDWARE_SYNC)
+	return err
+}
+
+// afterConnect restores the session on a new connection. Handlers,
+// listeners, subscribers and waiters belong to the Blynk value and carry
+// over as they are; what the server must see again is sent here: the
+// offline buffer first, then the sync, then OnConnect runs.
+func (g *Blynk) afterConnect() {
+	g.flushOffline()
+
+	g.lock.Lock()
+	reconnect := g.restored
+	g.restored = true
+	syncAll := g.syncOnConnect || reconnect && g.syncOnReconnect
+	onConnect := g.onConnect
+	g.lock.Unlock()
+	if syncAll {
+		if err := g.SyncAll(); err != nil {
+			slog.Printf("[ERROR] restore: sync failed, %s", err.Error())
+		}
+	}
+	if onConnect != nil {
+		onConnect()
+	}
+}
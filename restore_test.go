@@ -0,0 +1,74 @@
+package blynk
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// serveHandshake answers login and hardware info on conn and then reports
+// the commands the client sends.
+func serveHandshake(t *testing.T, conn net.Conn, cmds chan<- BlynkCommand) {
+	for i := 0; i < 2; i++ {
+		req := readServer(t, conn)
+		conn.Write(responseFrame(req.MessageId, BLYNK_SUCCESS))
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var g Blynk
+		buf, err := g.readFrame(conn)
+		if err != nil {
+			return
+		}
+		cmds <- BlynkCommand(buf[0])
+	}
+}
+
+func TestSubscriberSurvivesReconnect(t *testing.T) {
+	g := NewBlynk("token")
+	g.DisableLogo(true)
+	cmds := make(chan BlynkCommand, 10)
+
+	connect := func() {
+		client, server := net.Pipe()
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		go serveHandshake(t, server, cmds)
+		if err := g.ConnectConn(client); err != nil {
+			t.Fatalf("ConnectConn: %v", err)
+		}
+	}
+	receive := func(ch <-chan []string, want string) {
+		t.Helper()
+		g.recvMsg <- frame(BLYNK_CMD_HARDWARE, 0, "vw", "5", want)
+		select {
+		case values := <-ch:
+			if len(values) != 1 || values[0] != want {
+				t.Errorf("values = %v, want [%s]", values, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber got no %q", want)
+		}
+	}
+
+	connect()
+	ch, unsubscribe := g.Subscribe(5)
+	defer unsubscribe()
+	go g.processor()
+	defer close(g.cancel)
+	receive(ch, "before")
+
+	g.conn.Close()
+	connect()
+	select {
+	case cmd := <-cmds:
+		if cmd != BLYNK_CMD_HARDWARE_SYNC {
+			t.Errorf("command after reconnect = %s, want sync", CommandName(cmd))
+		}
+	case <-time.After(time.Second):
+		t.Error("no sync after reconnect")
+	}
+	receive(ch, "after")
+}
@@ -0,0 +1,77 @@
+package blynk
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// SetRetainReceived keeps the last value the server wrote to every virtual
+// pin. It is returned by LastReceived, and a value which arrived while the
+// pin had no writer handler is passed to the handler added later.
+func (g *Blynk) SetRetainReceived(state bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.retain = state
+	if !state {
+		g.lastReceived = nil
+		g.unhandled = nil
+	}
+}
+
+// LastReceived returns the last value written to the pin by the server,
+// multiple values joined with "." as for writer handlers.
+func (g *Blynk) LastReceived(pin int) (string, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	value, ok := g.lastReceived[pin]
+	return value, ok
+}
+
+func (g *Blynk) retainValue(pin int, values []string, handled bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.retain {
+		return
+	}
+	if g.lastReceived == nil {
+		g.lastReceived = make(map[int]string)
+		g.unhandled = make(map[int]bool)
+	}
+	g.lastReceived[pin] = strings.Join(values, ".")
+	if handled {
+		delete(g.unhandled, pin)
+	} else {
+		g.unhandled[pin] = true
+	}
+}
+
+// replayRetained passes the values which arrived before a handler existed
+// to the writer handlers now registered for them.
+func (g *Blynk) replayRetained() {
+	g.lock.Lock()
+	type replay struct {
+		pin   int
+		value string
+		fn    func(uint, io.Reader)
+	}
+	var todo []replay
+	for pin := range g.unhandled {
+		writer, ok := g.writers[uint(pin)]
+		if !ok {
+			continue
+		}
+		delete(g.unhandled, pin)
+		todo = append(todo, replay{pin: pin, value: g.lastReceived[pin], fn: writer})
+	}
+	g.lock.Unlock()
+
+	for _, r := range todo {
+		r := r
+		g.dispatchHandler(r.pin, func() {
+			var buf bytes.Buffer
+			buf.WriteString(r.value)
+			r.fn(uint(r.pin), &buf)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package blynk
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// SetWriteRetry makes sendMessage retry a write failed with a timeout up to
+// attempts times, sleeping backoff between tries. Only plain connections
+// are retried, TLS can not recover from a write timeout. Notify, Tweet and EMail are
+// not retried unless SetRetryNonIdempotent(true) is set, a retry could
+// deliver them twice.
+func (g *Blynk) SetWriteRetry(attempts int, backoff time.Duration) {
+	g.writeRetries = attempts
+	g.writeBackoff = backoff
+}
+
+func (g *Blynk) SetRetryNonIdempotent(state bool) {
+	g.retryAll = state
+}
+
+func isIdempotent(cmd BlynkCommand) bool {
+	switch cmd {
+	case BLYNK_CMD_NOTIFY, BLYNK_CMD_TWEET, BLYNK_CMD_EMAIL:
+		return false
+	}
+	return true
+}
+
+func isTransient(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// writeWithRetry continues a write that timed out with the bytes not yet
+// written, so the frames reach the server exactly once. A TLS connection is
+// broken for good after a write timeout and is never retried.
+func (g *Blynk) writeWithRetry(cmd BlynkCommand, buf []byte) error {
+	n, err := g.sendBytes(buf)
+	if err == nil || g.writeRetries <= 0 || !(g.retryAll || isIdempotent(cmd)) {
+		return err
+	}
+	if _, ok := g.conn.(*tls.Conn); ok {
+		return err
+	}
+	for attempt := 1; attempt <= g.writeRetries && isTransient(err); attempt++ {
+		buf = buf[n:]
+		slog.Printf("[DEBUG] send: retry %d after %s, %d bytes left", attempt, err.Error(), len(buf))
+		time.Sleep(g.writeBackoff)
+		if n, err = g.sendBytes(buf); err == nil {
+			return nil
+		}
+	}
+	return err
+}
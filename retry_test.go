@@ -0,0 +1,48 @@
+package blynk
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// partialConn accepts only limit bytes of the first write and then times out.
+type partialConn struct {
+	net.Conn
+	limit   int
+	written bytes.Buffer
+	failed  bool
+}
+
+func (c *partialConn) Write(b []byte) (int, error) {
+	if !c.failed && len(b) > c.limit {
+		c.failed = true
+		c.written.Write(b[:c.limit])
+		return c.limit, timeoutError{}
+	}
+	return c.written.Write(b)
+}
+
+func (c *partialConn) SetWriteDeadline(time.Time) error { return nil }
+
+func TestWriteRetryContinuesPartialWrite(t *testing.T) {
+	conn := &partialConn{limit: 3}
+	g := NewBlynk("token")
+	g.conn = conn
+	g.SetWriteRetry(2, 0)
+
+	buf := frame(BLYNK_CMD_HARDWARE, 7, "vw", "1", "23.5")
+	if err := g.writeWithRetry(BLYNK_CMD_HARDWARE, buf); err != nil {
+		t.Fatalf("writeWithRetry: %v", err)
+	}
+	if !bytes.Equal(conn.written.Bytes(), buf) {
+		t.Errorf("written % x, want % x", conn.written.Bytes(), buf)
+	}
+}
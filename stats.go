@@ -0,0 +1,124 @@
+package blynk
+
+import "time"
+
+type CmdStat struct {
+	Sent          uint64
+	Received      uint64
+	SentBytes     uint64
+	ReceivedBytes uint64
+}
+
+func (s CmdStat) AvgSentSize() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.SentBytes) / float64(s.Sent)
+}
+
+func (s CmdStat) AvgReceivedSize() float64 {
+	if s.Received == 0 {
+		return 0
+	}
+	return float64(s.ReceivedBytes) / float64(s.Received)
+}
+
+// CommandStats returns a copy of the per-command counters, sizes are the
+// message body lengths.
+func (g *Blynk) CommandStats() map[BlynkCommand]CmdStat {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	stats := make(map[BlynkCommand]CmdStat, len(g.cmdStats))
+	for cmd, s := range g.cmdStats {
+		stats[cmd] = *s
+	}
+	return stats
+}
+
+func (g *Blynk) countCommand(cmd BlynkCommand, size int, sent bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.cmdStats[cmd]
+	if !ok {
+		s = new(CmdStat)
+		g.cmdStats[cmd] = s
+	}
+	if sent {
+		s.Sent++
+		s.SentBytes += uint64(size)
+	} else {
+		s.Received++
+		s.ReceivedBytes += uint64(size)
+	}
+}
+
+// meterWindow is the number of seconds the throughput is averaged over.
+const meterWindow = 10
+
+// rateMeter counts bytes in one second buckets of the last meterWindow
+// seconds.
+type rateMeter struct {
+	total   uint64
+	buckets [meterWindow]uint64
+	stamps  [meterWindow]int64
+}
+
+func (m *rateMeter) add(n int, now time.Time) {
+	sec := now.Unix()
+	i := sec % meterWindow
+	if m.stamps[i] != sec {
+		m.stamps[i] = sec
+		m.buckets[i] = 0
+	}
+	m.buckets[i] += uint64(n)
+	m.total += uint64(n)
+}
+
+func (m *rateMeter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	var sum uint64
+	for i := range m.buckets {
+		if sec-m.stamps[i] < meterWindow {
+			sum += m.buckets[i]
+		}
+	}
+	return float64(sum) / meterWindow
+}
+
+// ThroughputSent returns the bytes per second written to the connection,
+// averaged over the last 10 seconds.
+func (g *Blynk) ThroughputSent() float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.sentMeter.rate(time.Now())
+}
+
+// ThroughputReceived returns the bytes per second read from the connection,
+// averaged over the last 10 seconds.
+func (g *Blynk) ThroughputReceived() float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.recvMeter.rate(time.Now())
+}
+
+func (g *Blynk) TotalBytesSent() uint64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.sentMeter.total
+}
+
+func (g *Blynk) TotalBytesReceived() uint64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.recvMeter.total
+}
+
+func (g *Blynk) countBytes(n int, sent bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if sent {
+		g.sentMeter.add(n, time.Now())
+	} else {
+		g.recvMeter.add(n, time.Now())
+	}
+}
@@ -0,0 +1,53 @@
+package blynk
+
+import (
+	"sync"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+const subscriberBuffer = 10
+
+// Subscribe returns a channel receiving the values written to the virtual
+// pin by the server. Values are dropped when the channel is full. The
+// returned function unsubscribes and closes the channel.
+func (g *Blynk) Subscribe(pin int) (<-chan []string, func()) {
+	ch := make(chan []string, subscriberBuffer)
+
+	g.lock.Lock()
+	g.subscribers[pin] = append(g.subscribers[pin], ch)
+	g.lock.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			subs := g.subscribers[pin]
+			for i, s := range subs {
+				if s == ch {
+					subs = append(subs[:i], subs[i+1:]...)
+					break
+				}
+			}
+			if len(subs) == 0 {
+				delete(g.subscribers, pin)
+			} else {
+				g.subscribers[pin] = subs
+			}
+			close(ch)
+		})
+	}
+}
+
+func (g *Blynk) publish(pin int, values []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, ch := range g.subscribers[pin] {
+		select {
+		case ch <- values:
+		default:
+			slog.Printf("[ERROR] subscriber of pin %d is full, value dropped", pin)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package blynk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VirtualReadSync requests the pin value and waits for the server to send it
+// back. It relies on Processing to dispatch incoming messages. Cancelling ctx
+// unregisters the waiter right away.
+func (g *Blynk) VirtualReadSync(ctx context.Context, pin int) ([]string, error) {
+	key := pinKey("vw", pin)
+	ch := g.addWaiter(key)
+	defer g.removeWaiter(key, ch)
+
+	if err := g.VirtualRead(pin); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.Values[2:], nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// VirtualWriteSync writes the value and waits up to timeout for the server
+// to reject it, a rejected write is returned as *BlynkError. The server
+// answers only failed writes, so no answer within timeout is success. A
+// timeout <= 0 returns as soon as the value is sent.
+func (g *Blynk) VirtualWriteSync(pin int, value string, timeout time.Duration) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_HARDWARE
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("vw")
+	msg.Body.AddInt(pin)
+	msg.Body.AddString(value)
+	msg.Head.Length = msg.Body.Len()
+
+	if err := g.requestWriteStatus(ctx, msg); err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	g.lastWritten[pin] = value
+	g.lock.Unlock()
+	return nil
+}
+
+// DigitalWriteContext writes the digital pin. If ctx has a deadline it waits
+// until then for the server to reject the write, no answer is success as the
+// server does not acknowledge accepted writes. Without a deadline it returns
+// once the write is sent.
+func (g *Blynk) DigitalWriteContext(ctx context.Context, pin int, value bool) error {
+	if err := g.acceptingWrites(); err != nil {
+		return err
+	}
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_HARDWARE
+	msg.Head.MessageId = g.getMessageID()
+	msg.Body.AddString("dw")
+	msg.Body.AddInt(pin)
+	msg.Body.AddBool(value)
+	msg.Head.Length = msg.Body.Len()
+
+	return g.requestWriteStatus(ctx, msg)
+}
+
+// DigitalReadSyncContext requests the digital pin state and waits for the
+// server to send it back, like VirtualReadSync it relies on Processing.
+func (g *Blynk) DigitalReadSyncContext(ctx context.Context, pin int) (bool, error) {
+	key := pinKey("dw", pin)
+	ch := g.addWaiter(key)
+	defer g.removeWaiter(key, ch)
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if err := g.DigitalRead(pin); err != nil {
+		return false, err
+	}
+
+	select {
+	case resp := <-ch:
+		if len(resp.Values) < 3 {
+			return false, fmt.Errorf("DigitalReadSyncContext: pin %d, no value", pin)
+		}
+		return resp.Values[2] != "0", nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// VirtualUpdate reads the pin value, passes it to fn and writes the result
+// back. Multiple values are joined with "." as for writer handlers. The
+// update is not atomic on the server, another client may write in between.
+func (g *Blynk) VirtualUpdate(pin int, fn func(old string) (string, error), timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	values, err := g.VirtualReadSync(ctx, pin)
+	if err != nil {
+		return fmt.Errorf("VirtualUpdate: read pin %d, %w", pin, err)
+	}
+	value, err := fn(strings.Join(values, "."))
+	if err != nil {
+		return err
+	}
+	return g.VirtualWrite(pin, value)
+}
@@ -0,0 +1,92 @@
+package blynk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVirtualWriteSyncNoAnswerIsSuccess(t *testing.T) {
+	g, server := newPipeClient(t)
+	go func() { readServer(t, server) }()
+
+	if err := g.VirtualWriteSync(1, "42", 50*time.Millisecond); err != nil {
+		t.Fatalf("VirtualWriteSync: %v", err)
+	}
+}
+
+func TestVirtualWriteSyncRejected(t *testing.T) {
+	g, server := newPipeClient(t)
+	go func() {
+		req := readServer(t, server)
+		server.Write(responseFrame(req.MessageId, BLYNK_ILLEGAL_COMMAND))
+	}()
+
+	err := g.VirtualWriteSync(1, "42", time.Second)
+	var blynkErr *BlynkError
+	if !errors.As(err, &blynkErr) {
+		t.Fatalf("VirtualWriteSync = %v, want *BlynkError", err)
+	}
+}
+
+func TestDigitalWriteContextWithoutDeadline(t *testing.T) {
+	g, server := newPipeClient(t)
+	go func() { readServer(t, server) }()
+
+	done := make(chan error, 1)
+	go func() { done <- g.DigitalWriteContext(context.Background(), 2, true) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("DigitalWriteContext: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DigitalWriteContext waited for an answer without a deadline")
+	}
+}
+
+func TestEMailAsyncWithoutProcessing(t *testing.T) {
+	g, server := newPipeClient(t)
+
+	if err := <-g.EMailAsync("a@example.com", "subject", "body"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("EMailAsync = %v, want ErrNotConnected", err)
+	}
+
+	g.SetFireAndForget(true)
+	go func() { readServer(t, server) }()
+	if err := <-g.EMailAsync("a@example.com", "subject", "body"); err != nil {
+		t.Errorf("EMailAsync fire and forget = %v", err)
+	}
+}
+
+func TestStopGracefullySendsDebouncedValue(t *testing.T) {
+	g, server := newPipeClient(t)
+	g.SetPinDebounce(3, time.Hour)
+
+	got := make(chan []string, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			got <- readServer(t, server).Values
+		}
+	}()
+	if err := g.VirtualWrite(3, "first"); err != nil {
+		t.Fatalf("VirtualWrite: %v", err)
+	}
+	if err := g.VirtualWrite(3, "last"); err != nil {
+		t.Fatalf("VirtualWrite: %v", err)
+	}
+	if err := g.StopGracefully(time.Second); err != nil {
+		t.Fatalf("StopGracefully: %v", err)
+	}
+	for _, want := range []string{"first", "last"} {
+		select {
+		case values := <-got:
+			if len(values) != 3 || values[2] != want {
+				t.Errorf("server got %v, want %s", values, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server did not get %s", want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package blynk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// NotifyPolicy decides what happens to a notification sent too early.
+type NotifyPolicy int
+
+const (
+	// NotifyDrop rejects it with ErrThrottled.
+	NotifyDrop NotifyPolicy = iota
+	// NotifyCoalesce keeps the latest one and sends it once the interval
+	// has passed, earlier pending ones are replaced.
+	NotifyCoalesce
+)
+
+// SetNotifyRate enforces at least minInterval between notifications, the
+// server rejects them with a quota error otherwise (about one per 15s).
+// Zero disables the throttle.
+func (g *Blynk) SetNotifyRate(minInterval time.Duration, policy NotifyPolicy) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.notifyRate = minInterval
+	g.notifyPolicy = policy
+}
+
+// throttleNotify reports whether msg must not be sent now. A coalesced
+// message is scheduled and reported with a nil error.
+func (g *Blynk) throttleNotify(msg string) (bool, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.notifyRate <= 0 {
+		return false, nil
+	}
+	wait := g.notifyRate - time.Since(g.lastNotify)
+	if wait <= 0 && g.notifyTimer == nil {
+		g.lastNotify = time.Now()
+		return false, nil
+	}
+	if g.notifyPolicy == NotifyDrop {
+		return true, fmt.Errorf("notify: %w, next in %s", ErrThrottled, wait.Round(time.Millisecond))
+	}
+	g.notifyPending = msg
+	if g.notifyTimer == nil {
+		g.notifyTimer = time.AfterFunc(wait, g.flushNotify)
+	}
+	return true, nil
+}
+
+func (g *Blynk) flushNotify() {
+	g.lock.Lock()
+	msg := g.notifyPending
+	g.notifyPending = ""
+	g.notifyTimer = nil
+	g.lastNotify = time.Now()
+	g.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.getTimeouts().Read)
+	defer cancel()
+	if err := g.notify(ctx, msg); err != nil {
+		slog.Printf("[ERROR] notify: coalesced notification failed, %s", err.Error())
+	}
+}
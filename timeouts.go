@@ -0,0 +1,53 @@
+package blynk
+
+import "time"
+
+// Timeouts groups the connection timeouts, a zero field keeps its default.
+//
+//	Connect   - TCP dial, default 10s
+//	Handshake - TLS handshake and the login/internal answers, default 5s
+//	Read      - waiting for the answer of Notify, Tweet, EMail, SetProperty, default 5s
+//	Write     - a single write on the connection, default none
+//	Idle      - no data received while Processing, default none
+type Timeouts struct {
+	Connect   time.Duration
+	Read      time.Duration
+	Write     time.Duration
+	Idle      time.Duration
+	Handshake time.Duration
+}
+
+var defaultTimeouts = Timeouts{
+	Connect:   time.Second * 10,
+	Read:      time.Second * 5,
+	Handshake: time.Second * 5,
+}
+
+func (g *Blynk) SetTimeouts(t Timeouts) {
+	if t.Connect == 0 {
+		t.Connect = defaultTimeouts.Connect
+	}
+	if t.Read == 0 {
+		t.Read = defaultTimeouts.Read
+	}
+	if t.Write == 0 {
+		t.Write = defaultTimeouts.Write
+	}
+	if t.Idle == 0 {
+		t.Idle = defaultTimeouts.Idle
+	}
+	if t.Handshake == 0 {
+		t.Handshake = defaultTimeouts.Handshake
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.timeouts = t
+	g.timeoutMAX = t.Handshake
+}
+
+func (g *Blynk) getTimeouts() Timeouts {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.timeouts
+}
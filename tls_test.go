@@ -0,0 +1,126 @@
+package blynk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// hostCert returns a self-signed certificate valid only for host, its PEM
+// serves as the CA of the client.
+func hostCert(t *testing.T, host string) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDialTLSByIPWithServerName(t *testing.T) {
+	cert, caPEM := hostCert(t, "blynk.test")
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	g := NewBlynk("token")
+	g.SetServer("127.0.0.1", addr.Port, true)
+	g.SetCAProvider(func() ([]byte, error) { return caPEM, nil })
+
+	if conn, err := g.dialTLS(addr); err == nil {
+		conn.Close()
+		t.Fatal("dialTLS verified the certificate against the IP")
+	}
+
+	g.SetServerName("blynk.test")
+	conn, err := g.dialTLS(addr)
+	if err != nil {
+		t.Fatalf("dialTLS with SetServerName: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().ServerName; got != "blynk.test" {
+		t.Errorf("ServerName = %q, want blynk.test", got)
+	}
+}
+
+func TestPlainFallbackOnlyOnConnectionFailures(t *testing.T) {
+	cert, caPEM := hostCert(t, "blynk.test")
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	g := NewBlynk("token")
+	addr := ln.Addr().(*net.TCPAddr)
+	g.SetServer("127.0.0.1", addr.Port, true)
+	g.SetCAProvider(func() ([]byte, error) { return caPEM, nil })
+	_, err = g.dialTLS(addr)
+	if err == nil || plainFallbackAllowed(err) {
+		t.Errorf("certificate error %v allows the plain fallback", err)
+	}
+
+	g.SetCAProvider(func() ([]byte, error) { return []byte("not a pem"), nil })
+	if _, err = g.dialTLS(addr); err == nil || plainFallbackAllowed(err) {
+		t.Errorf("CA error %v allows the plain fallback", err)
+	}
+
+	refused, _ := net.Listen("tcp", "127.0.0.1:0")
+	refusedAddr := refused.Addr().(*net.TCPAddr)
+	refused.Close()
+	g.SetCAProvider(func() ([]byte, error) { return caPEM, nil })
+	if _, err = g.dialTLS(refusedAddr); err == nil || !plainFallbackAllowed(err) {
+		t.Errorf("dial error %v does not allow the plain fallback", err)
+	}
+
+	if !plainFallbackAllowed(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}) {
+		t.Error("non-TLS endpoint does not allow the plain fallback")
+	}
+}
@@ -3,23 +3,180 @@ package blynk
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	slog "github.com/OloloevReal/go-simple-log"
 )
 
+const enqueueTimeout = time.Millisecond * 100
+
+// receiveBufferSize is advertised to the server as "buff-in", incoming
+// frames are accepted up to twice that size by default.
+const receiveBufferSize = 1024
+
+// SetMaxIncomingSize limits the body length of incoming frames, larger
+// frames are skipped. Zero restores the default.
+func (g *Blynk) SetMaxIncomingSize(n int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.maxIncoming = n
+}
+
+func (g *Blynk) getMaxIncoming() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.maxIncoming <= 0 {
+		return 2 * receiveBufferSize
+	}
+	return g.maxIncoming
+}
+
+// sendBufPool holds the buffers frames are encoded into before the write.
+var sendBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
 func (g *Blynk) sendMessage(msg BlynkMessage) (uint16, error) {
-	if err := g.sendBytes(msg.GetBytes()); err != nil {
+	if err := g.sendMessages(msg); err != nil {
 		return 0, err
 	}
 	return msg.Head.MessageId, nil
 }
 
+// sendMessages writes the messages to the connection with a single write.
+func (g *Blynk) sendMessages(msgs ...BlynkMessage) error {
+	_, err := g.sendOrLog(msgs...)
+	return err
+}
+
+// sendOrLog is sendMessages reporting whether a dry run only logged all of
+// msgs, callers waiting for an answer must not wait for one then.
+func (g *Blynk) sendOrLog(msgs ...BlynkMessage) (bool, error) {
+	if g.isCancelled() {
+		return false, ErrClosed
+	}
+	for i := range msgs {
+		msg := &msgs[i]
+		if g.readOnly && isStateChanging(msg.Head.Command) {
+			return false, ErrReadOnly
+		}
+		// responses carry the status code in the length field
+		if msg.Head.Command != BLYNK_CMD_RESPONSE {
+			msg.Head.Length = msg.Body.Len()
+		}
+	}
+	if msgs = g.dryRunFilter(msgs); len(msgs) == 0 {
+		return true, nil
+	}
+	if !g.isConnected() {
+		for i := range msgs {
+			if !g.bufferOffline(&msgs[i]) {
+				return false, ErrNotConnected
+			}
+		}
+		g.checkBackpressure()
+		return false, nil
+	}
+
+	g.lock.Lock()
+	onSend := g.onSend
+	g.lock.Unlock()
+
+	bp := sendBufPool.Get().(*[]byte)
+	buf := (*bp)[:0]
+	defer func() {
+		*bp = buf
+		sendBufPool.Put(bp)
+	}()
+
+	// the write is retried only if every message may be retried
+	retryCmd := msgs[0].Head.Command
+	for i := range msgs {
+		msg := &msgs[i]
+		if g.debug {
+			body := strings.Replace(msg.Body.String(), "\x00", " ", -1)
+			if msg.Head.Command == BLYNK_CMD_HW_LOGIN {
+				body = "***"
+			}
+			slog.Printf("[DEBUG] send %s (%s)", CommandName(msg.Head.Command), body)
+		}
+		if onSend != nil {
+			onSend(*msg)
+		}
+		start := len(buf)
+		buf = msg.appendTo(buf)
+		g.trackUnacked(msg, buf[start:])
+		if !isIdempotent(msg.Head.Command) {
+			retryCmd = msg.Head.Command
+		}
+	}
+
+	if err := g.writeWithRetry(retryCmd, buf); err != nil {
+		for i := range msgs {
+			g.dropUnacked(msgs[i].Head.MessageId)
+		}
+		return false, err
+	}
+	for i := range msgs {
+		g.countCommand(msgs[i].Head.Command, int(msgs[i].Body.Len()), true)
+		g.recordSent(&msgs[i])
+	}
+	g.checkBackpressure()
+	return false, nil
+}
+
+// SetDryRun makes state changing commands (writes, properties,
+// notifications) only go to the log and the OnSend hook instead of the
+// server. Reads and pings are still sent.
+func (g *Blynk) SetDryRun(state bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.dryRun = state
+}
+
+// dryRunFilter logs and removes the messages a dry run must not send.
+func (g *Blynk) dryRunFilter(msgs []BlynkMessage) []BlynkMessage {
+	g.lock.Lock()
+	dryRun, onSend := g.dryRun, g.onSend
+	g.lock.Unlock()
+	if !dryRun {
+		return msgs
+	}
+
+	var kept []BlynkMessage
+	for i := range msgs {
+		msg := &msgs[i]
+		if !isStateChanging(msg.Head.Command) {
+			kept = append(kept, *msg)
+			continue
+		}
+		slog.Printf("[INFO] dry run: %s (%s)", CommandName(msg.Head.Command), strings.Replace(msg.Body.String(), "\x00", " ", -1))
+		if onSend != nil {
+			onSend(*msg)
+		}
+	}
+	return kept
+}
+
+func isStateChanging(cmd BlynkCommand) bool {
+	switch cmd {
+	case BLYNK_CMD_HARDWARE, BLYNK_CMD_PROPERTY, BLYNK_CMD_NOTIFY, BLYNK_CMD_TWEET, BLYNK_CMD_EMAIL:
+		return true
+	}
+	return false
+}
+
 func (g *Blynk) sendCommand(cmd BlynkCommand) (uint16, error) {
 	msg := BlynkMessage{}
 	msg.Head.Command = cmd
@@ -29,27 +186,31 @@ func (g *Blynk) sendCommand(cmd BlynkCommand) (uint16, error) {
 }
 
 func (g *Blynk) sendString(cmd BlynkCommand, data string) (uint16, error) {
-	if g.conn == nil {
-		return 0, fmt.Errorf("send: conn *net.TCPConn is nil")
-	}
-
 	msg := BlynkMessage{}
 	msg.Head.Command = cmd
 	msg.Head.MessageId = g.getMessageID()
 	msg.Body.AddString(data)
 	msg.Head.Length = msg.Body.Len()
 
-	err := g.sendBytes(msg.GetBytes())
-	if err != nil {
+	if _, err := g.sendMessage(msg); err != nil {
 		return msg.Head.MessageId, err
 	}
 
 	return msg.Head.MessageId, nil
 }
 
-func (g *Blynk) sendBytes(buf []byte) error {
-	_, err := g.conn.Write(buf)
-	return err
+// sendBytes writes buf and returns how much of it was written.
+func (g *Blynk) sendBytes(buf []byte) (int, error) {
+	if g.conn == nil {
+		return 0, ErrNotConnected
+	}
+	if d := g.getTimeouts().Write; d > 0 {
+		g.conn.SetWriteDeadline(time.Now().Add(d))
+		defer g.conn.SetWriteDeadline(time.Time{})
+	}
+	n, err := g.conn.Write(buf)
+	g.countBytes(n, true)
+	return n, err
 }
 
 func (g *Blynk) receiveMessage(timeout time.Duration) (*BlynkHead, error) {
@@ -63,7 +224,7 @@ func (g *Blynk) receiveMessage(timeout time.Duration) (*BlynkHead, error) {
 
 	err = binary.Read(bufReader, binary.BigEndian, resp)
 	if err != nil {
-		slog.Printf("[DEBUG] receiveMessage: binary read error, ", err.Error())
+		slog.Printf("[DEBUG] receiveMessage: binary read error, %s", err.Error())
 		return nil, err
 	}
 
@@ -79,26 +240,123 @@ func (g *Blynk) receive(timeout time.Duration) ([]byte, error) {
 	g.conn.SetDeadline(time.Now().Add(timeout))
 	defer g.conn.SetDeadline(time.Time{})
 
-	buf := make([]byte, 1024)
-	cnt, err := g.conn.Read(buf)
+	buf, err := g.readFrame(g.conn)
 	if err == io.EOF {
 		slog.Printf("[DEBUG] receive: EOF")
 		return nil, err
 	}
 
 	if err2, ok := err.(net.Error); ok && err2.Timeout() {
-		slog.Printf("[DEBUG] is timeout: %v %d\n", err2.Timeout(), cnt)
+		slog.Printf("[DEBUG] is timeout: %v\n", err2.Timeout())
 		return nil, err2
 	}
 
 	if err != nil {
-		slog.Printf("[DEBUG] receive: error, ", err.Error())
+		slog.Printf("[DEBUG] receive: error, %s", err.Error())
 		return nil, err
 	}
 
 	return buf, nil
 }
 
+// readFrame reads exactly one frame, the 5 byte head and then as many body
+// bytes as the head declares, however the stream is split into packets.
+func (g *Blynk) readFrame(r io.Reader) ([]byte, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	g.countBytes(len(head), false)
+
+	length := int(binary.BigEndian.Uint16(head[3:5]))
+	if BlynkCommand(head[0]) == BLYNK_CMD_RESPONSE {
+		// responses carry the status code in place of the length
+		length = 0
+	}
+	if limit := g.getMaxIncoming(); length > limit {
+		// the length field is all we have to stay in step with the stream
+		n, err := io.CopyN(io.Discard, r, int64(length))
+		g.countBytes(int(n), false)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w, %s frame of %d bytes exceeds %d", ErrFrameTooLarge, CommandName(BlynkCommand(head[0])), length, limit)
+	}
+
+	frame := make([]byte, 5+length)
+	copy(frame, head)
+	n, err := io.ReadFull(r, frame[5:])
+	g.countBytes(n, false)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+// awaitResponse reads frames without Processing until the response for id
+// arrives. Other frames are offered to other, the ones it does not take are
+// queued for the processor.
+func (g *Blynk) awaitResponse(deadline time.Time, id uint16, other func(*BlynkRespose) bool) (*BlynkRespose, error) {
+	for {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, fmt.Errorf("%w waiting for response id-%d", ErrTimeout, id)
+		}
+		frame, err := g.receive(timeout)
+		if err != nil {
+			if err2, ok := err.(net.Error); ok && err2.Timeout() {
+				return nil, fmt.Errorf("%w waiting for response id-%d", ErrTimeout, id)
+			}
+			return nil, err
+		}
+		resps, err := g.parseResponce(frame)
+		if err != nil {
+			return nil, err
+		}
+		for _, resp := range resps {
+			if resp.Command == BLYNK_CMD_RESPONSE && resp.MessageId == id {
+				return resp, nil
+			}
+		}
+		if len(resps) > 0 && other != nil && other(resps[0]) {
+			continue
+		}
+		g.deferFrame(frame)
+	}
+}
+
+// drainInline reads the frames arriving within wait, e.g. hints the server
+// sends after a response, and handles them like awaitResponse.
+func (g *Blynk) drainInline(wait time.Duration, other func(*BlynkRespose) bool) {
+	for {
+		frame, err := g.receive(wait)
+		if err != nil {
+			return
+		}
+		resps, err := g.parseResponce(frame)
+		if err != nil || len(resps) == 0 {
+			return
+		}
+		if other == nil || !other(resps[0]) {
+			g.deferFrame(frame)
+		}
+	}
+}
+
+// deferFrame queues a frame read outside the receiver for the processor, it
+// is dropped when the queue is full.
+func (g *Blynk) deferFrame(frame []byte) {
+	select {
+	case g.recvMsg <- frame:
+	default:
+		atomic.AddUint64(&g.dropped, 1)
+		slog.Printf("[ERROR] receive: queue full, frame dropped")
+	}
+}
+
 func (g *Blynk) receiver() error {
 	slog.Printf("[INFO] Receiver: started")
 	defer slog.Printf("[INFO] Receiver: finished")
@@ -106,7 +364,7 @@ func (g *Blynk) receiver() error {
 		return fmt.Errorf("receiver: *Blynk or *net.TCPConn is nil")
 	}
 	g.conn.SetReadDeadline(time.Time{})
-	buf := make([]byte, 1024)
+	idle := g.getTimeouts().Idle
 	for {
 		select {
 		case <-g.cancel:
@@ -114,28 +372,58 @@ func (g *Blynk) receiver() error {
 			return nil
 		default:
 			{
-				cntBytes, err := g.conn.Read(buf)
+				if idle > 0 {
+					g.conn.SetReadDeadline(time.Now().Add(idle))
+				}
+				frame, err := g.readFrame(g.conn)
+				if errors.Is(err, ErrFrameTooLarge) {
+					slog.Printf("[ERROR] receiver: %s, skipped", err.Error())
+					break
+				}
 				if err == io.EOF {
 					slog.Printf("[DEBUG] receiver: EOF")
-					break
+					g.emitEvent(EventServerKick, err)
+					return err
 				}
 				if err2, ok := err.(net.Error); ok && err2.Timeout() {
 					slog.Printf("[DEBUG] receiver: is timeout: %v\n", err2.Timeout())
+					if idle > 0 && !g.isCancelled() {
+						return fmt.Errorf("receiver: no data for %s, %w", idle, err)
+					}
 					break
 				}
 				if err != nil {
 					slog.Printf("[ERROR] receiver: error, %s", err.Error())
 					return err
 				}
-				//slog.Printf("[DEBUG] receiver send: % x", buf[:cntBytes])
-				bufToSend := make([]byte, cntBytes)
-				copy(bufToSend, buf[:cntBytes])
-				g.recvMsg <- bufToSend
+				if !g.enqueue(frame) {
+					return nil
+				}
 			}
 		}
 	}
+}
+
+// enqueue hands the buffer to the processor without stalling the read loop
+// for longer than enqueueTimeout, the buffer is dropped when the queue stays
+// full. It returns false when the client is being stopped.
+func (g *Blynk) enqueue(buf []byte) bool {
+	t := time.NewTimer(enqueueTimeout)
+	defer t.Stop()
+	select {
+	case g.recvMsg <- buf:
+	case <-t.C:
+		dropped := atomic.AddUint64(&g.dropped, 1)
+		slog.Printf("[ERROR] receiver: queue is full, message dropped (total dropped: %d)", dropped)
+	case <-g.cancel:
+		slog.Printf("[DEBUG] receiver: cancel received")
+		return false
+	}
+	return true
+}
 
-	return nil
+func (g *Blynk) DroppedMessages() uint64 {
+	return atomic.LoadUint64(&g.dropped)
 }
 
 func (g *Blynk) processor() {
@@ -155,29 +443,46 @@ func (g *Blynk) processor() {
 				}
 
 				for _, resp := range br {
+					size := int(resp.Status)
+					if resp.Command == BLYNK_CMD_RESPONSE {
+						size = 0
+					}
+					g.countCommand(resp.Command, size, false)
+					g.recordReceived(resp)
+					slog.Printf("[DEBUG] received %s (%s)", CommandName(resp.Command), strings.Join(resp.Values, " "))
+
 					switch resp.Command {
 					case BLYNK_CMD_HARDWARE:
-						if g.OnReadFunc != nil {
-							g.OnReadFunc(resp)
+						g.notifyReadListeners(resp)
+						if len(resp.Values) < 2 {
+							slog.Printf("[ERROR] Processor received malformed msg: %v", resp)
+							continue
 						}
 
+						g.resolveWaiters(resp)
+
 						switch resp.Values[0] {
 						case "vr":
 							pin, _ := strconv.Atoi(resp.Values[1])
 							if reader, ok := g.readers[uint(pin)]; !ok {
 								slog.Printf("[DEBUG] failed to find reader, Pin: %d", pin)
 							} else {
-								var buf bytes.Buffer
-								reader(uint(pin), &buf)
-								slog.Printf("[DEBUG] reader result: %s", buf.String())
-								g.VirtualWrite(pin, buf.String())
+								g.dispatchHandler(pin, func() {
+									var buf bytes.Buffer
+									reader(uint(pin), &buf)
+									slog.Printf("[DEBUG] reader result: %s", buf.String())
+									// internal reply, sent even while StopGracefully drains
+									g.virtualWrite(pin, buf.String())
+								})
 							}
 						case "vw":
 							pin, _ := strconv.Atoi(resp.Values[1])
-							if writer, ok := g.writers[uint(pin)]; !ok {
+							g.publish(pin, resp.Values[2:])
+							writer, ok := g.writers[uint(pin)]
+							g.retainValue(pin, resp.Values[2:], ok)
+							if !ok {
 								slog.Printf("[DEBUG] failed to find reader, Pin: %d", pin)
 							} else {
-								var buf bytes.Buffer
 								// buf.WriteString(resp.Values[2])
 								// slog.Printf("[DEBUG] value: %s", resp.Values[2])
 
@@ -186,13 +491,25 @@ func (g *Blynk) processor() {
 								data := strings.Join(resp.Values[2:], ".")
 								slog.Printf("[DEBUG] value: %s", data)
 
-								buf.WriteString(data)
-								writer(uint(pin), &buf)
+								g.dispatchHandler(pin, func() {
+									var buf bytes.Buffer
+									buf.WriteString(data)
+									writer(uint(pin), &buf)
+								})
 							}
 						}
 
 					case BLYNK_CMD_RESPONSE:
+						if !g.handlePong(resp.MessageId) {
+							g.ackUnacked(resp.MessageId, resp.Status)
+							g.resolveWaiters(resp)
+						}
+						if resp.Status == BLYNK_NOT_AUTHENTICATED {
+							g.handleNotAuthenticated()
+						}
 
+					case BLYNK_CMD_INTERNAL:
+						g.handleInternal(resp.Values)
 					case BLYNK_CMD_PING:
 						g.sendPingResponse(resp.MessageId)
 					default:
@@ -206,28 +523,33 @@ func (g *Blynk) processor() {
 
 }
 
+// parseResponce decodes every complete frame of buf, a truncated frame at
+// the end is reported as an error after the preceding frames.
 func (g *Blynk) parseResponce(buf []byte) ([]*BlynkRespose, error) {
 	var resps []*BlynkRespose
-	var resp *BlynkRespose
 	flagStart := 0
-	if len(buf) >= 5 {
-		for len(buf) >= flagStart+5 {
-			resp = new(BlynkRespose)
-			resp.parseHead(buf[flagStart : flagStart+5])
-			lenBody := int(resp.Status)
-
-			if resp.Command == BLYNK_CMD_HARDWARE && resp.Status > 0 && resp.Status < 1024 {
-				if len(buf) >= flagStart+5+lenBody {
-					resp.parseBody(buf[flagStart+5 : flagStart+5+lenBody])
-				} else {
-					slog.Printf("[ERROR] parseResponce: failed parse body, length of buf less than flag")
-				}
-
-			}
+	for len(buf) >= flagStart+5 {
+		resp := new(BlynkRespose)
+		resp.parseHead(buf[flagStart : flagStart+5])
+		lenBody := int(resp.Status)
+		if resp.Command == BLYNK_CMD_RESPONSE {
+			// responses carry the status code in place of the length
+			lenBody = 0
+		}
 
-			resps = append(resps, resp)
-			flagStart += 5 + lenBody
+		if len(buf) < flagStart+5+lenBody {
+			return resps, fmt.Errorf("parseResponce: truncated %s frame, need %d bytes, have %d", CommandName(resp.Command), lenBody, len(buf)-flagStart-5)
 		}
+		if lenBody > 0 {
+			resp.parseBody(buf[flagStart+5 : flagStart+5+lenBody])
+			g.decompressResponse(resp)
+		}
+
+		resps = append(resps, resp)
+		flagStart += 5 + lenBody
+	}
+	if flagStart != len(buf) {
+		return resps, fmt.Errorf("parseResponce: %d trailing bytes", len(buf)-flagStart)
 	}
 
 	return resps, nil
@@ -240,6 +562,23 @@ func (g *Blynk) sendPing() error {
 	return nil
 }
 
+// ping sends a ping and waits for its response on the connection directly,
+// it must not be used while Processing is running.
+func (g *Blynk) ping(timeout time.Duration) error {
+	id, err := g.sendCommand(BLYNK_CMD_PING)
+	if err != nil {
+		return err
+	}
+	resp, err := g.receiveMessage(timeout)
+	if err != nil {
+		return err
+	}
+	if resp.Command != BLYNK_CMD_RESPONSE || resp.MessageId != id || resp.Length != BLYNK_SUCCESS {
+		return fmt.Errorf("ping: unexpected response, message id-%d, code-%d", resp.MessageId, resp.Length)
+	}
+	return nil
+}
+
 func (g *Blynk) sendPingResponse(id uint16) error {
 	msg := BlynkMessage{}
 	msg.Head.Command = BLYNK_CMD_RESPONSE
@@ -0,0 +1,169 @@
+package blynk
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// newPipeClient returns a connected client without Processing and the
+// server end of its connection.
+func newPipeClient(t *testing.T) (*Blynk, net.Conn) {
+	t.Helper()
+	g := NewBlynk("token")
+	g.DisableLogo(true)
+	client, server := net.Pipe()
+	g.conn = client
+	g.setConnected(true)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return g, server
+}
+
+func frame(cmd BlynkCommand, id uint16, values ...string) []byte {
+	msg := BlynkMessage{}
+	msg.Head.Command = cmd
+	msg.Head.MessageId = id
+	for _, v := range values {
+		msg.Body.AddString(v)
+	}
+	buf, _ := msg.Encode()
+	return buf
+}
+
+func responseFrame(id uint16, status uint16) []byte {
+	msg := BlynkMessage{}
+	msg.Head.Command = BLYNK_CMD_RESPONSE
+	msg.Head.MessageId = id
+	msg.Head.Length = status
+	buf, _ := msg.Encode()
+	return buf
+}
+
+// readServer reads one frame the client sent, it is safe to use from the
+// goroutine playing the server.
+func readServer(t *testing.T, conn net.Conn) *BlynkRespose {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var g Blynk
+	buf, err := g.readFrame(conn)
+	if err != nil {
+		t.Errorf("server read: %v", err)
+		return &BlynkRespose{}
+	}
+	resps, err := g.parseResponce(buf)
+	if err != nil || len(resps) != 1 {
+		t.Errorf("server parse: %v, %d frames", err, len(resps))
+		return &BlynkRespose{}
+	}
+	return resps[0]
+}
+
+func TestSendInternalReadsHintsAfterResponse(t *testing.T) {
+	g, server := newPipeClient(t)
+
+	go func() {
+		req := readServer(t, server)
+		server.Write(responseFrame(req.MessageId, BLYNK_SUCCESS))
+		server.Write(frame(BLYNK_CMD_INTERNAL, 0, "email", "0"))
+		server.Write(frame(BLYNK_CMD_HARDWARE, 0, "vw", "1", "42"))
+	}()
+
+	if err := g.sendInternal(); err != nil {
+		t.Fatalf("sendInternal: %v", err)
+	}
+	if g.Capabilities().EMail {
+		t.Error("email hint sent after the response was not applied")
+	}
+	select {
+	case buf := <-g.recvMsg:
+		resps, _ := g.parseResponce(buf)
+		if len(resps) != 1 || resps[0].Command != BLYNK_CMD_HARDWARE {
+			t.Errorf("queued frame = %v, want the hardware frame", resps)
+		}
+	default:
+		t.Error("hardware frame was not queued for the processor")
+	}
+}
+
+// readerConn serves reads from r, as if the server sent all of it at once.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func TestReceiverDecodesConcatenatedFrames(t *testing.T) {
+	g, _ := newPipeClient(t)
+	stream := append(frame(BLYNK_CMD_HARDWARE, 1, "vw", "1", "on"), frame(BLYNK_CMD_HARDWARE, 2, "vw", "2", "off")...)
+	g.conn = readerConn{Conn: g.conn, r: bytes.NewReader(stream)}
+
+	if err := g.receiver(); err != io.EOF {
+		t.Fatalf("receiver = %v, want EOF", err)
+	}
+	if n := len(g.recvMsg); n != 2 {
+		t.Fatalf("queued frames = %d, want 2", n)
+	}
+	for _, want := range []string{"on", "off"} {
+		resps, err := g.parseResponce(<-g.recvMsg)
+		if err != nil || len(resps) != 1 || resps[0].Values[2] != want {
+			t.Errorf("frame = %v, %v, want value %q", resps, err, want)
+		}
+	}
+}
+
+func TestDryRunNotifyReturnsAtOnce(t *testing.T) {
+	for _, processing := range []bool{false, true} {
+		g, _ := newPipeClient(t)
+		g.processingUsing = processing
+		g.SetDryRun(true)
+
+		start := time.Now()
+		if err := g.Notify("hello"); err != nil {
+			t.Errorf("processing %v: Notify = %v", processing, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("processing %v: Notify took %s", processing, d)
+		}
+	}
+}
+
+func TestReceiverDecodesOneByteReads(t *testing.T) {
+	g, _ := newPipeClient(t)
+	stream := append(frame(BLYNK_CMD_HARDWARE, 7, "vw", "12", "hello world"), responseFrame(8, BLYNK_ILLEGAL_COMMAND)...)
+	g.conn = readerConn{Conn: g.conn, r: iotest.OneByteReader(bytes.NewReader(stream))}
+
+	if err := g.receiver(); err != io.EOF {
+		t.Fatalf("receiver = %v, want EOF", err)
+	}
+	if n := len(g.recvMsg); n != 2 {
+		t.Fatalf("queued frames = %d, want 2", n)
+	}
+
+	resps, err := g.parseResponce(<-g.recvMsg)
+	if err != nil || len(resps) != 1 {
+		t.Fatalf("parse: %v, %d frames", err, len(resps))
+	}
+	hw := resps[0]
+	if hw.Command != BLYNK_CMD_HARDWARE || hw.MessageId != 7 || hw.Status != uint16(len("vw\x0012\x00hello world")) {
+		t.Errorf("head = %s id=%d len=%d", CommandName(hw.Command), hw.MessageId, hw.Status)
+	}
+	if len(hw.Values) != 3 || hw.Values[0] != "vw" || hw.Values[1] != "12" || hw.Values[2] != "hello world" {
+		t.Errorf("body = %q", hw.Values)
+	}
+
+	resps, err = g.parseResponce(<-g.recvMsg)
+	if err != nil || len(resps) != 1 {
+		t.Fatalf("parse: %v, %d frames", err, len(resps))
+	}
+	if r := resps[0]; r.Command != BLYNK_CMD_RESPONSE || r.MessageId != 8 || r.Status != BLYNK_ILLEGAL_COMMAND {
+		t.Errorf("response = %s id=%d status=%d", CommandName(r.Command), r.MessageId, r.Status)
+	}
+}
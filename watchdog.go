@@ -0,0 +1,66 @@
+package blynk
+
+import (
+	"time"
+
+	slog "github.com/OloloevReal/go-simple-log"
+)
+
+// EnableWatchdog checks the connection every check interval and reconnects
+// when the link is unhealthy or no pong arrived for maxMissedPings
+// heartbeats, even if the receiver sees no error. Zero disables it.
+func (g *Blynk) EnableWatchdog(check time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.watchdogStop != nil {
+		close(g.watchdogStop)
+		g.watchdogStop = nil
+	}
+	if check <= 0 {
+		return
+	}
+	g.watchdogStop = make(chan struct{})
+	go g.watchdog(check, g.watchdogStop)
+}
+
+func (g *Blynk) watchdog(check time.Duration, stop chan struct{}) {
+	t := time.NewTicker(check)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if reason := g.watchdogCheck(); reason != "" {
+				slog.Printf("[ERROR] Watchdog: %s", reason)
+				g.requestReconnect("watchdog, " + reason)
+			}
+		case <-stop:
+			return
+		case <-g.cancel:
+			return
+		}
+	}
+}
+
+// watchdogCheck returns why the connection should be replaced, or "".
+func (g *Blynk) watchdogCheck() string {
+	if !g.processingUsing || !g.isConnected() {
+		return ""
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.health.unhealthy {
+		return "link is unhealthy"
+	}
+	if !g.pausedAt.IsZero() {
+		return ""
+	}
+	last := g.health.lastPong
+	if last.Before(g.connectedAt) {
+		last = g.connectedAt
+	}
+	limit := maxMissedPings * g.heartbeat
+	if !last.IsZero() && time.Since(last) > limit {
+		return "no pong for " + time.Since(last).Round(time.Second).String()
+	}
+	return ""
+}